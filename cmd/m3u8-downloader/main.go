@@ -1,28 +1,112 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"m3u8-downloader/internal/config"
 	"m3u8-downloader/internal/downloader"
+	"m3u8-downloader/pkg/utils"
 )
 
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
 	// Parse command line arguments
 	m3u8URL := flag.String("url", "", "M3U8 playlist URL (required)")
-	outputDir := flag.String("dir", "downloads", "Directory for temporary files")
-	output := flag.String("output", "output.ts", "Output file name")
+	outputDir := flag.String("dir", "downloads", "Directory for job working directories; only files a job creates are removed")
+	keepSegments := flag.Bool("keep-segments", false, "Keep the downloaded segments in the job directory")
+	stale := flag.String("stale", config.StaleResume, "Job directory left by an interrupted run: resume from its segments, or clean it")
+	output := flag.String("output", "output.ts", "Output file name or template such as \"{host}/{name}_{pdt}.ts\", or - to stream to stdout")
+	onExists := flag.String("on-exists", config.ExistsOverwrite, "When the output file exists: overwrite, skip, or suffix to save as name_1.ts")
 	maxRetry := flag.Int("retry", 5, "Max retry times when download fails")
-	threads := flag.Int("threads", 10, "Number of concurrent downloads")
-	timeout := flag.Int("timeout", 30, "Timeout in seconds for HTTP requests")
+	threads := flag.Int("threads", 10, "Number of concurrent downloads, the starting point with -adaptive")
+	adaptive := flag.Bool("adaptive", false, "Adjust the number of concurrent downloads to the measured throughput, latency and errors")
+	minThreads := flag.Int("min-threads", 1, "Fewest concurrent downloads with -adaptive")
+	maxThreads := flag.Int("max-threads", 32, "Most concurrent downloads with -adaptive")
+	timeout := flag.Int("timeout", 30, "Timeout in seconds for HTTP requests; segment bodies only without -idle-timeout and -min-speed")
+	connectTimeout := flag.Duration("connect-timeout", 10*time.Second, "Timeout for the TCP connect and the TLS handshake, each")
+	firstByteTimeout := flag.Duration("first-byte-timeout", 20*time.Second, "Timeout for the response headers once the request is sent")
+	idleTimeout := flag.Duration("idle-timeout", 20*time.Second, "Abort a segment transfer that receives no data for this long (0 disables)")
+	minSpeed := flag.String("min-speed", "", "Abort a segment transfer slower than this many bytes per second over -speed-window, e.g. 50K")
+	speedWindow := flag.Duration("speed-window", 10*time.Second, "Window over which -min-speed is measured")
 	validate := flag.Bool("validate", true, "Validate integrity of downloaded segments")
+	timingTolerance := flag.Duration("timing-tolerance", 500*time.Millisecond, "Allowed difference between measured and EXTINF durations, and between consecutive segments")
+	retryDelay := flag.Duration("retry-delay", time.Second, "Backoff before the first retry, doubled on each attempt")
+	retryMaxDelay := flag.Duration("retry-max-delay", 30*time.Second, "Upper bound for the retry backoff")
+	hedge := flag.Float64("hedge", 0, "Send a second request for a segment still running after this percentile of recent segment latencies, e.g. 95 (0 disables)")
+	hedgeBudget := flag.Float64("hedge-budget", 5, "Most segment requests that may be hedged, in percent")
+	chunkThreshold := flag.String("chunk-threshold", "", "Download segments of at least this size in parallel byte ranges, e.g. 32M (default: off)")
+	chunkSize := flag.String("chunk-size", "8M", "Size of the byte ranges of a chunked segment download")
+	limitRate := flag.String("limit-rate", "", "Bandwidth limit shared by all downloads, e.g. 500K or 2M bytes per second")
+	hostConnections := flag.Int("host-connections", 0, "Concurrent segment requests per host (default: no limit beyond -threads)")
+	control := flag.String("control", "", "Address of the HTTP control API for changing limits while running, e.g. 127.0.0.1:7070")
+	controlToken := flag.String("control-token", "", "Bearer token the control API requires; needed to listen on a non-loopback address")
+	reorderWindow := flag.Int("reorder-window", 0, "Segments downloaded ahead of the write position (default: 4x threads)")
+	reorderMem := flag.Int("reorder-mem", 64, "Megabytes of out-of-order segments kept in memory before spilling to disk")
+	start := flag.String("start", "", "Start of the time range as HH:MM:SS, seconds or an RFC 3339 wall-clock time")
+	end := flag.String("end", "", "End of the time range as HH:MM:SS, seconds or an RFC 3339 wall-clock time")
+	duration := flag.String("duration", "", "Length of the time range from -start as HH:MM:SS or seconds")
+	segments := flag.String("segments", "", "Media sequence numbers to download, e.g. \"10-20,50,100-\"")
+	every := flag.Int("every", 0, "Download only every Nth segment of the selection")
+	ads := flag.String("ads", config.AdsKeep, "Ad breaks: keep, skip, or split the output into parts at them")
+	splitDuration := flag.Duration("split-duration", 0, "Start a new output file once this much media is written, e.g. 1h")
+	splitAlign := flag.Bool("split-align", false, "Cut -split-duration parts at wall-clock multiples of the program date-time")
+	splitSize := flag.String("split-size", "", "Start a new output file before it grows past this size, e.g. 2G")
+	splitOn := flag.String("split-on", "", "Comma separated split points: discontinuity, pdt")
+	splitName := flag.String("split-name", config.DefaultSplitTemplate, "Name template for split parts: {name}, {ext}, {index}, {seq}, {start}, {date}")
+	chapters := flag.String("chapters", "", "Comma separated chapter sources: discontinuity, daterange, pdt")
+	chapterFormat := flag.String("chapter-format", config.ChapterFormatFFMetadata, "Chapter sidecar format: ffmetadata or json")
+	fixTimestamps := flag.Bool("fix-timestamps", true, "Rewrite timestamps across discontinuities so the output timeline is continuous")
+	spaceCheck := flag.Bool("space-check", true, "Check free disk space before starting and while writing")
+	minFree := flag.String("min-free", "512M", "Free space to keep in the job and output directories")
+	lowSpace := flag.String("low-space", config.LowSpacePause, "When free space drops below -min-free: pause until space is freed, or abort")
+	var headers stringList
+	flag.Var(&headers, "header", "Extra request header \"Name: Value\", prefix with \"@host1,host2 \" to scope it (repeatable)")
+	userAgent := flag.String("user-agent", "", "Override the User-Agent header")
+	cookies := flag.String("cookies", "", "Netscape cookies.txt file to send with requests")
+	basicAuth := flag.String("auth", "", "Basic auth credentials as user:password")
+	bearer := flag.String("bearer", "", "Bearer token sent in the Authorization header")
+	authHosts := flag.String("auth-hosts", "", "Comma separated hosts that receive credentials (default: playlist host)")
+	proxy := flag.String("proxy", "", "Proxy URL (http://, https://, socks5://, socks5h://) or \"direct\" (default: from environment)")
+	noProxy := flag.String("no-proxy", "", "Comma separated hosts contacted without a proxy")
+	var proxyRules stringList
+	flag.Var(&proxyRules, "proxy-rule", "Per-host proxy as \"host1,host2=PROXY_URL\" or \"host=direct\" (repeatable)")
+	caFile := flag.String("cacert", "", "PEM CA bundle trusted in addition to the system roots")
+	var clientCerts, serverNames, pins stringList
+	flag.Var(&clientCerts, "cert", "Client certificate as \"CERT.pem[,KEY.pem]\" (repeatable)")
+	flag.Var(&serverNames, "sni", "SNI override as \"host=servername\" (repeatable)")
+	flag.Var(&pins, "pin", "Public-key pin as \"host1,host2=sha256//BASE64[;sha256//BASE64]\" (repeatable)")
+	tlsMin := flag.String("tls-min", "1.2", "Minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification (lab use only)")
 	flag.Parse()
 
+	// Keep stdout clean for the media stream when piping
+	logOut := os.Stdout
+	if *output == "-" {
+		logOut = os.Stderr
+		flag.CommandLine.SetOutput(os.Stderr)
+
+		// Report a closed pipe as a write error instead of being killed by SIGPIPE
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
 	if *m3u8URL == "" {
-		fmt.Println("Error: M3U8 URL is required")
+		fmt.Fprintln(logOut, "Error: M3U8 URL is required")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -37,19 +121,275 @@ func main() {
 		time.Duration(*timeout)*time.Second,
 		*validate,
 	)
+	switch *onExists {
+	case config.ExistsOverwrite, config.ExistsSkip, config.ExistsSuffix:
+		cfg.OutputExists = *onExists
+	default:
+		fmt.Fprintf(logOut, "Error: invalid -on-exists %q, expected overwrite, skip or suffix\n", *onExists)
+		os.Exit(1)
+	}
+	cfg.KeepSegments = *keepSegments
+	cfg.SpaceCheck = *spaceCheck
+	if size, err := utils.ParseSize(*minFree); err == nil {
+		cfg.MinFree = size
+	} else if *minFree == "0" {
+		cfg.MinFree = 0
+	} else {
+		fmt.Fprintf(logOut, "Error: invalid -min-free: %v\n", err)
+		os.Exit(1)
+	}
+	switch *lowSpace {
+	case config.LowSpacePause, config.LowSpaceAbort:
+		cfg.LowSpace = *lowSpace
+	default:
+		fmt.Fprintf(logOut, "Error: invalid -low-space %q, expected pause or abort\n", *lowSpace)
+		os.Exit(1)
+	}
+	switch *stale {
+	case config.StaleResume, config.StaleClean:
+		cfg.StaleJobs = *stale
+	default:
+		fmt.Fprintf(logOut, "Error: invalid -stale %q, expected resume or clean\n", *stale)
+		os.Exit(1)
+	}
+	if *connectTimeout < 0 || *firstByteTimeout < 0 || *idleTimeout < 0 {
+		fmt.Fprintln(logOut, "Error: -connect-timeout, -first-byte-timeout and -idle-timeout cannot be negative")
+		os.Exit(1)
+	}
+	cfg.ConnectTimeout = *connectTimeout
+	cfg.FirstByteTimeout = *firstByteTimeout
+	cfg.IdleTimeout = *idleTimeout
+	if *minSpeed != "" {
+		speed, err := utils.ParseSize(*minSpeed)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -min-speed: %v\n", err)
+			os.Exit(1)
+		}
+		if *speedWindow <= 0 {
+			fmt.Fprintln(logOut, "Error: -speed-window must be positive")
+			os.Exit(1)
+		}
+		cfg.MinSpeed = speed
+	}
+	cfg.SpeedWindow = *speedWindow
+	if *minThreads < 1 || *maxThreads < *minThreads {
+		fmt.Fprintln(logOut, "Error: -min-threads must be at least 1 and no more than -max-threads")
+		os.Exit(1)
+	}
+	cfg.Adaptive = *adaptive
+	cfg.MinThreads = *minThreads
+	cfg.MaxThreads = *maxThreads
+	if *hedge < 0 || *hedge >= 100 || *hedgeBudget < 0 || *hedgeBudget > 100 {
+		fmt.Fprintln(logOut, "Error: -hedge must be below 100 and -hedge-budget between 0 and 100")
+		os.Exit(1)
+	}
+	cfg.HedgePercentile = *hedge
+	cfg.HedgeBudget = *hedgeBudget
+	if *chunkThreshold != "" && *chunkThreshold != "0" {
+		threshold, err := utils.ParseSize(*chunkThreshold)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -chunk-threshold: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ChunkThreshold = threshold
+	}
+	if size, err := utils.ParseSize(*chunkSize); err != nil || size <= 0 {
+		fmt.Fprintln(logOut, "Error: -chunk-size must be a positive size such as 8M")
+		os.Exit(1)
+	} else {
+		cfg.ChunkSize = size
+	}
+	if *limitRate != "" {
+		rate, err := utils.ParseSize(*limitRate)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -limit-rate: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.RateLimit = rate
+	}
+	if *hostConnections < 0 {
+		fmt.Fprintln(logOut, "Error: -host-connections cannot be negative")
+		os.Exit(1)
+	}
+	cfg.HostConnections = *hostConnections
+	cfg.ControlAddr = *control
+	cfg.ControlToken = *controlToken
+	cfg.TimingTolerance = *timingTolerance
+	cfg.ReorderWindow = *reorderWindow
+	cfg.FixTimestamps = *fixTimestamps
+	switch *ads {
+	case config.AdsKeep, config.AdsSkip, config.AdsSplit:
+		cfg.AdBreaks = *ads
+	default:
+		fmt.Fprintf(logOut, "Error: invalid -ads %q, expected keep, skip or split\n", *ads)
+		os.Exit(1)
+	}
+	if *ads == config.AdsSplit && *output == "-" {
+		fmt.Fprintln(logOut, "Error: -ads split cannot be used with -output -")
+		os.Exit(1)
+	}
+	cfg.SplitDuration = *splitDuration
+	cfg.SplitAlign = *splitAlign
+	if *splitSize != "" {
+		size, err := utils.ParseSize(*splitSize)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -split-size: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.SplitSize = size
+	}
+	cfg.SplitOn = utils.SplitList(*splitOn)
+	for _, point := range cfg.SplitOn {
+		switch point {
+		case config.SplitOnDiscontinuity, config.SplitOnPDT:
+		default:
+			fmt.Fprintf(logOut, "Error: invalid -split-on point %q, expected discontinuity or pdt\n", point)
+			os.Exit(1)
+		}
+	}
+	if !strings.Contains(*splitName, "{index}") {
+		fmt.Fprintln(logOut, "Error: -split-name must contain {index}")
+		os.Exit(1)
+	}
+	cfg.SplitTemplate = *splitName
+	if (cfg.SplitDuration > 0 || cfg.SplitSize > 0 || len(cfg.SplitOn) > 0) && *output == "-" {
+		fmt.Fprintln(logOut, "Error: -split-duration, -split-size and -split-on cannot be used with -output -")
+		os.Exit(1)
+	}
+	cfg.Chapters = utils.SplitList(*chapters)
+	for _, source := range cfg.Chapters {
+		switch source {
+		case config.ChaptersDiscontinuity, config.ChaptersDateRange, config.ChaptersPDT:
+		default:
+			fmt.Fprintf(logOut, "Error: invalid -chapters source %q, expected discontinuity, daterange or pdt\n", source)
+			os.Exit(1)
+		}
+	}
+	switch *chapterFormat {
+	case config.ChapterFormatFFMetadata, config.ChapterFormatJSON:
+		cfg.ChapterFormat = *chapterFormat
+	default:
+		fmt.Fprintf(logOut, "Error: invalid -chapter-format %q, expected ffmetadata or json\n", *chapterFormat)
+		os.Exit(1)
+	}
+	if len(cfg.Chapters) > 0 && *output == "-" {
+		fmt.Fprintln(logOut, "Error: -chapters cannot be used with -output -")
+		os.Exit(1)
+	}
+	cfg.ReorderMemory = int64(*reorderMem) * 1024 * 1024
+	cfg.RetryDelay = *retryDelay
+	cfg.RetryMaxDelay = *retryMaxDelay
+	if *start != "" {
+		t, err := utils.ParseTimePoint(*start)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -start: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ClipStart = &t
+	}
+	if *end != "" {
+		t, err := utils.ParseTimePoint(*end)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -end: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ClipEnd = &t
+	}
+	if *duration != "" {
+		if *end != "" {
+			fmt.Fprintln(logOut, "Error: -end and -duration cannot be used together")
+			os.Exit(1)
+		}
+		t, err := utils.ParseTimePoint(*duration)
+		if err != nil || t.IsWall() || t.Offset <= 0 {
+			fmt.Fprintf(logOut, "Error: invalid -duration %q\n", *duration)
+			os.Exit(1)
+		}
+		cfg.ClipDuration = t.Offset
+	}
+	if *segments != "" {
+		ranges, err := utils.ParseRanges(*segments)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: invalid -segments: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.SegmentRanges = ranges
+	}
+	cfg.SegmentEvery = *every
+	if (*segments != "" || *every > 1) && (*start != "" || *end != "" || *duration != "") {
+		fmt.Fprintln(logOut, "Error: -segments and -every cannot be combined with -start, -end or -duration")
+		os.Exit(1)
+	}
+	cfg.UserAgent = *userAgent
+	cfg.CookieFile = *cookies
+	cfg.BasicAuth = *basicAuth
+	cfg.BearerToken = *bearer
+	cfg.AuthHosts = utils.SplitList(*authHosts)
+	for _, h := range headers {
+		header, err := utils.ParseHeader(h)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Headers = append(cfg.Headers, header)
+	}
+	cfg.Proxy = *proxy
+	cfg.NoProxy = utils.SplitList(*noProxy)
+	for _, r := range proxyRules {
+		rule, err := utils.ParseProxyRule(r)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ProxyRules = append(cfg.ProxyRules, rule)
+	}
+	cfg.CAFile = *caFile
+	cfg.Insecure = *insecure
+	for _, c := range clientCerts {
+		cfg.ClientCerts = append(cfg.ClientCerts, utils.ParseClientCert(c))
+	}
+	for _, s := range serverNames {
+		host, name, found := strings.Cut(s, "=")
+		if !found {
+			fmt.Fprintf(logOut, "Error: invalid SNI override %q, expected host=servername\n", s)
+			os.Exit(1)
+		}
+		if cfg.ServerNames == nil {
+			cfg.ServerNames = make(map[string]string)
+		}
+		cfg.ServerNames[strings.TrimSpace(host)] = strings.TrimSpace(name)
+	}
+	for _, p := range pins {
+		pin, err := utils.ParsePin(p)
+		if err != nil {
+			fmt.Fprintf(logOut, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Pins = append(cfg.Pins, pin)
+	}
+	minVersion, err := utils.ParseTLSVersion(*tlsMin)
+	if err != nil {
+		fmt.Fprintf(logOut, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.TLSMinVersion = minVersion
 
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
-		fmt.Printf("Error creating output directory: %v\n", err)
+		fmt.Fprintf(logOut, "Error creating output directory: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Create downloader and start downloading
 	dl := downloader.New(cfg)
 	if err := dl.Download(); err != nil {
-		fmt.Printf("Error: %v\n", err)
+		if errors.Is(err, downloader.ErrBrokenPipe) {
+			fmt.Fprintln(logOut, "Output pipe closed, download stopped")
+			os.Exit(141)
+		}
+		fmt.Fprintf(logOut, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("Download completed successfully!")
+	fmt.Fprintln(logOut, "Download completed successfully!")
 }
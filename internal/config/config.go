@@ -1,27 +1,201 @@
 package config
 
-import "time"
+import (
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// Ad break policies for Config.AdBreaks
+const (
+	AdsKeep  = "keep"  // Download ad breaks like any other segment
+	AdsSkip  = "skip"  // Leave ad breaks out of the output
+	AdsSplit = "split" // Leave ad breaks out and start a new output file after each
+)
+
+// Chapter sources for Config.Chapters
+const (
+	ChaptersDiscontinuity = "discontinuity" // A chapter per run of segments between discontinuities
+	ChaptersDateRange     = "daterange"     // A chapter per EXT-X-DATERANGE
+	ChaptersPDT           = "pdt"           // A chapter wherever the program date-time jumps
+)
+
+// Chapter sidecar formats for Config.ChapterFormat
+const (
+	ChapterFormatFFMetadata = "ffmetadata"
+	ChapterFormatJSON       = "json"
+)
+
+// What to do when an output file already exists, for Config.OutputExists
+const (
+	ExistsOverwrite = "overwrite" // Replace the existing file
+	ExistsSkip      = "skip"      // Leave the existing file alone and download nothing
+	ExistsSuffix    = "suffix"    // Save under a free name: video_1.ts, video_2.ts, ...
+)
+
+// What to do with the job directory of an interrupted run, for Config.StaleJobs
+const (
+	StaleResume = "resume" // Reuse the segments it saved
+	StaleClean  = "clean"  // Delete it and start over
+)
+
+// What to do when free disk space runs low during a download, for Config.LowSpace
+const (
+	LowSpacePause = "pause" // Wait until space is freed
+	LowSpaceAbort = "abort" // Stop the download, keeping saved segments for a resume
+)
+
+// Split points for Config.SplitOn
+const (
+	SplitOnDiscontinuity = "discontinuity" // Start a new file at each discontinuity
+	SplitOnPDT           = "pdt"           // Start a new file wherever the program date-time jumps
+)
+
+// DefaultSplitTemplate names the parts of a split output video_part01.ts, video_part02.ts, ...
+const DefaultSplitTemplate = "{name}_part{index}{ext}"
 
 // Config holds the downloader configuration
 type Config struct {
 	URL           string
-	OutputDir     string
+	OutputDir     string // Parent of the per-download job directories
 	Output        string
 	MaxRetry      int
 	Threads       int
 	Timeout       time.Duration
 	ValidateFiles bool // Option to validate integrity of downloaded segments
+
+	// Output may be a template with {host}, {path}, {name}, {resolution}, {bandwidth},
+	// {codecs}, {pdt}, {seq}, {date} and {index}, filled in once the playlist is loaded
+	OutputExists string // ExistsOverwrite, ExistsSkip or ExistsSuffix
+
+	// TimingTolerance is how far measured segment durations, gaps and overlaps
+	// may deviate from the playlist before validation reports them
+	TimingTolerance time.Duration
+
+	// Retry policy shared by playlist and segment requests
+	RetryDelay    time.Duration // Backoff before the first retry, doubled on each attempt
+	RetryMaxDelay time.Duration // Upper bound for the backoff delay
+
+	// Job directory options
+	KeepSegments bool   // Keep the downloaded segments in the job directory
+	StaleJobs    string // StaleResume or StaleClean
+
+	// Disk space protection
+	SpaceCheck bool   // Compare the estimated download size with free space before starting
+	MinFree    int64  // Bytes that must stay free in the job and output directories
+	LowSpace   string // LowSpacePause or LowSpaceAbort
+
+	// Stall detection for segment transfers. While it is enabled, Timeout covers
+	// segment requests only up to the response headers. A transfer that stalls or
+	// breaks off is retried from where it stopped when the server accepts range requests.
+	ConnectTimeout   time.Duration // TCP connect and TLS handshake, each
+	FirstByteTimeout time.Duration // Wait for the response headers once the request is sent
+	IdleTimeout      time.Duration // Longest time without receiving data
+	MinSpeed         int64         // Bytes per second over SpeedWindow below which a transfer is dropped, unchecked when zero
+	SpeedWindow      time.Duration
+
+	// Chunked downloads: a segment of at least ChunkThreshold bytes from a server
+	// accepting range requests is fetched in ChunkSize ranges at once, sharing the
+	// workers with the other segments. Off when ChunkThreshold is zero.
+	ChunkThreshold int64
+	ChunkSize      int64
+
+	// Adaptive concurrency: the number of concurrent requests starts at Threads and
+	// moves between MinThreads and MaxThreads with the measured throughput and errors
+	Adaptive   bool
+	MinThreads int
+	MaxThreads int
+
+	// Hedged requests: a segment request still running after the HedgePercentile
+	// latency of recent segments gets a second request, for at most HedgeBudget
+	// percent of the requests. Off when HedgePercentile is zero.
+	HedgePercentile float64
+	HedgeBudget     float64
+
+	// Throttling, adjustable while running through the control API or, outside
+	// Windows, SIGUSR1 (halve the rate) and SIGUSR2 (double it)
+	RateLimit       int64  // Bytes per second read by all requests together, unlimited when zero
+	HostConnections int    // Concurrent segment requests per host, unlimited when zero
+	ControlAddr     string // Listen address of the HTTP control API, disabled when empty
+	ControlToken    string // Bearer token the control API requires, needed to listen beyond loopback
+
+	// Streaming merge options
+	ReorderWindow int   // Segments downloaded ahead of the write position, 4x Threads when zero
+	ReorderMemory int64 // Bytes of out-of-order segments kept in memory before spilling to disk
+	FixTimestamps bool  // Rewrite timestamps and continuity counters across discontinuities
+
+	// Time-range clipping, nil bounds leave that side of the range open
+	ClipStart    *utils.TimePoint
+	ClipEnd      *utils.TimePoint
+	ClipDuration time.Duration // Length of the clip from ClipStart, used when ClipEnd is nil
+
+	// Segment selection by media sequence number
+	SegmentRanges []utils.Range // Sequence numbers to download, all when empty
+	SegmentEvery  int           // Keep every Nth selected segment when above 1
+
+	AdBreaks string // What to do with ad breaks: AdsKeep, AdsSkip or AdsSplit
+
+	// Output splitting, always at segment boundaries; a single file when none is set
+	SplitDuration time.Duration // Start a new file once this much media is written
+	SplitAlign    bool          // Cut SplitDuration parts at wall-clock multiples of the program date-time
+	SplitSize     int64         // Start a new file before it grows past this many bytes
+	SplitOn       []string      // Split points: SplitOnDiscontinuity, SplitOnPDT
+	SplitTemplate string        // Part names, with {name}, {ext}, {index}, {seq}, {start} and {date}
+
+	// Chapter markers written next to the output, none when Chapters is empty
+	Chapters      []string // Chapter sources: ChaptersDiscontinuity, ChaptersDateRange, ChaptersPDT
+	ChapterFormat string   // Sidecar format: ChapterFormatFFMetadata or ChapterFormatJSON
+
+	// HTTP request options shared by playlist and segment requests
+	UserAgent   string         // Overrides the default User-Agent when set
+	Headers     []utils.Header // Extra request headers, optionally scoped to hosts
+	CookieFile  string         // Netscape cookies.txt loaded into the shared cookie jar
+	BasicAuth   string         // "user:password" sent as HTTP Basic credentials
+	BearerToken string         // Token sent as HTTP Bearer credentials
+	AuthHosts   []string       // Hosts that receive credentials; defaults to the playlist host
+
+	// Proxy options; without an explicit proxy the standard proxy environment variables apply
+	Proxy      string            // http://, https://, socks5:// or socks5h:// URL, or "direct"
+	NoProxy    []string          // Hosts always contacted directly
+	ProxyRules []utils.ProxyRule // Per-host proxy routing, first match wins
+
+	// TLS options
+	CAFile        string             // PEM bundle trusted in addition to the system roots
+	ClientCerts   []utils.ClientCert // Client certificates offered for mutual TLS
+	TLSMinVersion uint16             // Minimum TLS version, TLS 1.2 when zero
+	ServerNames   map[string]string  // SNI overrides keyed by host
+	Pins          []utils.Pin        // Public-key pins per host
+	Insecure      bool               // Skip certificate verification, for lab use only
 }
 
 // New creates a new Config instance with the provided parameters
 func New(url, outputDir, output string, maxRetry, threads int, timeout time.Duration, validateFiles bool) *Config {
 	return &Config{
-		URL:           url,
-		OutputDir:     outputDir,
-		Output:        output,
-		MaxRetry:      maxRetry,
-		Threads:       threads,
-		Timeout:       timeout,
-		ValidateFiles: validateFiles,
+		URL:              url,
+		OutputDir:        outputDir,
+		Output:           output,
+		MaxRetry:         maxRetry,
+		Threads:          threads,
+		Timeout:          timeout,
+		ValidateFiles:    validateFiles,
+		TimingTolerance:  500 * time.Millisecond,
+		OutputExists:     ExistsOverwrite,
+		StaleJobs:        StaleResume,
+		SpaceCheck:       true,
+		MinFree:          512 * 1024 * 1024,
+		LowSpace:         LowSpacePause,
+		ChapterFormat:    ChapterFormatFFMetadata,
+		SplitTemplate:    DefaultSplitTemplate,
+		RetryDelay:       time.Second,
+		RetryMaxDelay:    30 * time.Second,
+		ReorderMemory:    64 * 1024 * 1024,
+		ConnectTimeout:   10 * time.Second,
+		FirstByteTimeout: 20 * time.Second,
+		IdleTimeout:      20 * time.Second,
+		SpeedWindow:      10 * time.Second,
+		HedgeBudget:      5,
+		ChunkSize:        8 * 1024 * 1024,
+		MinThreads:       1,
+		MaxThreads:       32,
 	}
 }
@@ -0,0 +1,325 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"m3u8-downloader/internal/config"
+	"m3u8-downloader/internal/mpegts"
+)
+
+// cueState follows ad break markers while a media playlist is parsed
+type cueState struct {
+	inBreak bool
+	fresh   bool    // The next segment opens the break
+	expired bool    // The planned duration ran out without a CUE-IN
+	marker  string  // Tag that opened the current break
+	planned float64 // Announced break duration in seconds
+	elapsed float64
+
+	dateRanges []dateRange
+}
+
+// dateRange is an EXT-X-DATERANGE tag carrying SCTE-35 break markers
+type dateRange struct {
+	id      string
+	start   time.Time
+	end     time.Time // Zero until a duration, END-DATE or matching SCTE35-IN is seen
+	planned float64
+	out     bool
+	index   int // Index of the segment following the tag, for playlists without dates
+}
+
+// tag handles an ad break marker tag, index is the number of segments parsed so far
+func (c *cueState) tag(line string, index int) {
+	name, value, _ := strings.Cut(line, ":")
+
+	switch name {
+	case "#EXT-X-CUE-OUT":
+		// Either a bare duration or a DURATION attribute
+		planned, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			planned, _ = strconv.ParseFloat(parseAttributes(value)["DURATION"], 64)
+		}
+		c.open("EXT-X-CUE-OUT", planned)
+	case "#EXT-X-CUE-OUT-CONT":
+		// Joining a playlist in the middle of a break
+		if !c.inBreak && !c.expired {
+			c.open("EXT-X-CUE-OUT-CONT", contDuration(value))
+		}
+	case "#EXT-X-CUE-IN":
+		c.close()
+	case "#EXT-X-SCTE35":
+		c.scte35(parseAttributes(value))
+	case "#EXT-X-DATERANGE":
+		c.dateRange(parseAttributes(value), index)
+	}
+}
+
+// contDuration reads the break duration from an EXT-X-CUE-OUT-CONT tag, written
+// either as ElapsedTime=5,Duration=30 or as 5/30
+func contDuration(value string) float64 {
+	if _, total, found := strings.Cut(value, "/"); found {
+		d, _ := strconv.ParseFloat(total, 64)
+		return d
+	}
+	d, _ := strconv.ParseFloat(parseAttributes(value)["Duration"], 64)
+	return d
+}
+
+// scte35 handles an EXT-X-SCTE35 tag, using its CUE-OUT/CUE-IN attributes when
+// present and the splice_info_section in CUE otherwise
+func (c *cueState) scte35(attrs map[string]string) {
+	planned, _ := strconv.ParseFloat(attrs["DURATION"], 64)
+
+	switch {
+	case attrs["CUE-OUT"] == "YES":
+		c.open("EXT-X-SCTE35", planned)
+		return
+	case attrs["CUE-OUT"] == "CONT":
+		if !c.inBreak && !c.expired {
+			c.open("EXT-X-SCTE35", planned)
+		}
+		return
+	case attrs["CUE-IN"] == "YES":
+		c.close()
+		return
+	}
+
+	signal, err := spliceSignal(attrs["CUE"])
+	if err != nil {
+		return
+	}
+	switch signal {
+	case mpegts.SpliceOut:
+		c.open("EXT-X-SCTE35", planned)
+	case mpegts.SpliceIn:
+		c.close()
+	}
+}
+
+// spliceSignal decodes a SCTE-35 message given in hex (0x...) or base64
+func spliceSignal(value string) (mpegts.SpliceSignal, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		data, err = hex.DecodeString(value[2:])
+	} else {
+		data, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil {
+		return mpegts.SpliceNone, err
+	}
+	return mpegts.ParseSpliceInfo(data)
+}
+
+// dateRange records an EXT-X-DATERANGE tag with SCTE35-OUT or SCTE35-IN
+func (c *cueState) dateRange(attrs map[string]string, index int) {
+	_, isOut := attrs["SCTE35-OUT"]
+	_, isIn := attrs["SCTE35-IN"]
+	if !isOut && !isIn {
+		return
+	}
+
+	start, _ := parseProgramDateTime(attrs["START-DATE"])
+	end, _ := parseProgramDateTime(attrs["END-DATE"])
+	planned, err := strconv.ParseFloat(attrs["DURATION"], 64)
+	if err != nil {
+		planned, _ = strconv.ParseFloat(attrs["PLANNED-DURATION"], 64)
+	}
+	if end.IsZero() && planned > 0 && !start.IsZero() {
+		end = start.Add(seconds(planned))
+	}
+
+	// SCTE35-IN closes the break opened under the same ID
+	if isIn && !isOut {
+		for i := range c.dateRanges {
+			r := &c.dateRanges[i]
+			if r.id == attrs["ID"] && r.out && r.end.IsZero() {
+				r.end = start
+				if !end.IsZero() {
+					r.end = end
+				}
+			}
+		}
+	}
+
+	c.dateRanges = append(c.dateRanges, dateRange{
+		id:      attrs["ID"],
+		start:   start,
+		end:     end,
+		planned: planned,
+		out:     isOut,
+		index:   index,
+	})
+}
+
+// open starts a break at the next segment
+func (c *cueState) open(marker string, planned float64) {
+	c.inBreak = true
+	c.fresh = true
+	c.expired = false
+	c.marker = marker
+	c.planned = planned
+	c.elapsed = 0
+}
+
+// close ends the break before the next segment
+func (c *cueState) close() {
+	c.inBreak = false
+	c.expired = false
+}
+
+// segment marks the next segment when it falls inside a break
+func (c *cueState) segment(seg *Segment) {
+	if !c.inBreak {
+		return
+	}
+
+	seg.AdBreak = true
+	if c.fresh {
+		seg.AdMarker = c.marker
+		seg.AdPlanned = c.planned
+		c.fresh = false
+	}
+
+	// Without a CUE-IN the break ends once its announced duration has played
+	c.elapsed += seg.Duration
+	if c.planned > 0 && c.elapsed >= c.planned-0.001 {
+		c.inBreak = false
+		c.expired = true
+	}
+}
+
+// finish marks the segments covered by EXT-X-DATERANGE breaks, by program
+// date-time when the playlist has it and by tag position otherwise
+func (c *cueState) finish(segments []Segment) {
+	for i, r := range c.dateRanges {
+		if !r.out {
+			continue
+		}
+
+		first := true
+		mark := func(seg *Segment) {
+			seg.AdBreak = true
+			if first {
+				seg.AdMarker = "EXT-X-DATERANGE"
+				seg.AdPlanned = r.planned
+				first = false
+			}
+		}
+
+		if !r.start.IsZero() && len(segments) > 0 && !segments[0].ProgramDateTime.IsZero() {
+			for j := range segments {
+				pdt := segments[j].ProgramDateTime
+				if !pdt.Before(r.start) && (r.end.IsZero() || pdt.Before(r.end)) {
+					mark(&segments[j])
+				}
+			}
+			continue
+		}
+
+		// Without dates the break runs until the next SCTE35-IN or its planned duration
+		stop := len(segments)
+		for _, next := range c.dateRanges[i+1:] {
+			if !next.out {
+				stop = next.index
+				break
+			}
+		}
+		var elapsed float64
+		for j := r.index; j < stop && j < len(segments); j++ {
+			if r.planned > 0 && elapsed >= r.planned-0.001 {
+				break
+			}
+			mark(&segments[j])
+			elapsed += segments[j].Duration
+		}
+	}
+}
+
+// adBreak is a run of consecutive segments inside an ad break
+type adBreak struct {
+	first, last int     // Media sequence numbers of the first and last segment
+	duration    float64 // Sum of the EXTINF durations
+	planned     float64 // Duration announced by the marker, 0 when unknown
+	marker      string
+}
+
+// findAdBreaks groups the segments marked as ads into breaks
+func findAdBreaks(segments []Segment) []adBreak {
+	var breaks []adBreak
+	for i, seg := range segments {
+		if !seg.AdBreak {
+			continue
+		}
+		if seg.AdMarker != "" || i == 0 || !segments[i-1].AdBreak {
+			breaks = append(breaks, adBreak{first: seg.Sequence, planned: seg.AdPlanned, marker: seg.AdMarker})
+		}
+		b := &breaks[len(breaks)-1]
+		b.last = seg.Sequence
+		b.duration += seg.Duration
+	}
+	return breaks
+}
+
+// applyAdPolicy reports the ad breaks found and drops their segments unless they are kept
+func (d *Downloader) applyAdPolicy(segments []Segment) ([]Segment, error) {
+	breaks := findAdBreaks(segments)
+	if len(breaks) == 0 {
+		if d.config.AdBreaks != config.AdsKeep {
+			d.println("No ad breaks found in the playlist")
+		}
+		return segments, nil
+	}
+
+	var total float64
+	d.printf("Found %d ad breaks:\n", len(breaks))
+	for _, b := range breaks {
+		line := fmt.Sprintf("  segments %d-%d: %.1fs", b.first, b.last, b.duration)
+		if b.planned > 0 {
+			line += fmt.Sprintf(" (planned %.1fs)", b.planned)
+		}
+		if b.marker != "" {
+			line += " from " + b.marker
+		}
+		d.println(line)
+		total += b.duration
+	}
+
+	if d.config.AdBreaks == config.AdsKeep {
+		return segments, nil
+	}
+
+	var kept []Segment
+	for _, seg := range segments {
+		if !seg.AdBreak {
+			kept = append(kept, seg)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("every segment is inside an ad break")
+	}
+
+	d.printf("Skipping %.1fs of ads\n", total)
+	d.adBreaks = breaks
+	return kept, nil
+}
+
+// adPartStarts marks the segments that follow an ad break and so start a new output file
+func (d *Downloader) adPartStarts(segments []Segment) []bool {
+	starts := make([]bool, len(segments))
+	for i := 1; i < len(segments); i++ {
+		for _, b := range d.adBreaks {
+			if segments[i-1].Sequence < b.first && segments[i].Sequence > b.last {
+				starts[i] = true
+				break
+			}
+		}
+	}
+	return starts
+}
@@ -0,0 +1,210 @@
+package downloader
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// SCTE-35 splice_insert messages for an immediate break start and end
+const (
+	spliceOutHex    = "0xFC300000000000000000FFF00605000000017FDF000000000000"
+	spliceInBase64  = "/DAAAAAAAAAAAP/wBgUAAAABf18AAAAAAAA="
+	adPlaylistStart = "#EXTM3U\n#EXT-X-TARGETDURATION:10\n"
+)
+
+// adPlaylist builds a media playlist of 10 second segments. Lines starting with
+// '#' are copied, any other line stands for a segment.
+func adPlaylist(lines ...string) string {
+	var b strings.Builder
+	b.WriteString(adPlaylistStart)
+	n := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			b.WriteString(line + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "#EXTINF:10,\nseg%d.ts\n", n)
+		n++
+	}
+	return b.String()
+}
+
+func TestParseMediaPlaylistAdBreaks(t *testing.T) {
+	const pdt = "#EXT-X-PROGRAM-DATE-TIME:2024-05-01T18:00:00Z"
+
+	tests := []struct {
+		name    string
+		lines   []string
+		ads     []int          // Indexes of the segments inside a break
+		markers map[int]string // Marker of the segments that open a break
+		planned map[int]float64
+	}{
+		{
+			name:    "CUE-OUT closed by CUE-IN",
+			lines:   []string{"s", "#EXT-X-CUE-OUT:60", "s", "s", "#EXT-X-CUE-IN", "s"},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-CUE-OUT"},
+			planned: map[int]float64{1: 60},
+		},
+		{
+			name:    "CUE-OUT expires after its duration",
+			lines:   []string{"s", "#EXT-X-CUE-OUT:DURATION=20", "s", "s", "s"},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-CUE-OUT"},
+			planned: map[int]float64{1: 20},
+		},
+		{
+			name:    "CUE-OUT-CONT does not reopen an expired break",
+			lines:   []string{"#EXT-X-CUE-OUT:10", "s", "#EXT-X-CUE-OUT-CONT:10/10", "s"},
+			ads:     []int{0},
+			markers: map[int]string{0: "EXT-X-CUE-OUT"},
+			planned: map[int]float64{0: 10},
+		},
+		{
+			name:    "CUE-OUT-CONT at the start of the playlist",
+			lines:   []string{"#EXT-X-CUE-OUT-CONT:ElapsedTime=10,Duration=60", "s", "#EXT-X-CUE-OUT-CONT:ElapsedTime=20,Duration=60", "s", "#EXT-X-CUE-IN", "s"},
+			ads:     []int{0, 1},
+			markers: map[int]string{0: "EXT-X-CUE-OUT-CONT"},
+			planned: map[int]float64{0: 60},
+		},
+		{
+			name:    "CUE-OUT-CONT in the short form",
+			lines:   []string{"#EXT-X-CUE-OUT-CONT:50/60", "s", "#EXT-X-CUE-IN", "s"},
+			ads:     []int{0},
+			markers: map[int]string{0: "EXT-X-CUE-OUT-CONT"},
+			planned: map[int]float64{0: 60},
+		},
+		{
+			name:    "EXT-X-SCTE35 CUE-OUT and CUE-IN attributes",
+			lines:   []string{"s", "#EXT-X-SCTE35:CUE-OUT=YES,DURATION=60", "s", "#EXT-X-SCTE35:CUE-OUT=CONT", "s", "#EXT-X-SCTE35:CUE-IN=YES", "s"},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-SCTE35"},
+			planned: map[int]float64{1: 60},
+		},
+		{
+			name:    "EXT-X-SCTE35 CUE-OUT=CONT at the start of the playlist",
+			lines:   []string{"#EXT-X-SCTE35:CUE-OUT=CONT", "s", "s", "#EXT-X-SCTE35:CUE-IN=YES", "s"},
+			ads:     []int{0, 1},
+			markers: map[int]string{0: "EXT-X-SCTE35"},
+		},
+		{
+			name:    "EXT-X-SCTE35 with hex and base64 CUE",
+			lines:   []string{"s", "#EXT-X-SCTE35:CUE=" + spliceOutHex, "s", "s", "#EXT-X-SCTE35:CUE=\"" + spliceInBase64 + "\"", "s"},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-SCTE35"},
+		},
+		{
+			name:  "EXT-X-SCTE35 with an undecodable CUE",
+			lines: []string{"s", "#EXT-X-SCTE35:CUE=0xZZ", "s"},
+		},
+		{
+			name: "DATERANGE matched by program date-time",
+			lines: []string{
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:10Z",DURATION=20,SCTE35-OUT=` + spliceOutHex,
+				pdt, "s", "s", "s", "s",
+			},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-DATERANGE"},
+			planned: map[int]float64{1: 20},
+		},
+		{
+			name: "DATERANGE closed by SCTE35-IN under the same ID",
+			lines: []string{
+				pdt, "s",
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:10Z",SCTE35-OUT=` + spliceOutHex,
+				"s", "s", "s",
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:40Z",SCTE35-IN=` + spliceOutHex,
+				"s",
+			},
+			ads:     []int{1, 2, 3},
+			markers: map[int]string{1: "EXT-X-DATERANGE"},
+		},
+		{
+			name: "DATERANGE matched by tag position",
+			lines: []string{
+				"s",
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:10Z",SCTE35-OUT=` + spliceOutHex,
+				"s", "s",
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:30Z",SCTE35-IN=` + spliceOutHex,
+				"s",
+			},
+			ads:     []int{1, 2},
+			markers: map[int]string{1: "EXT-X-DATERANGE"},
+		},
+		{
+			name: "DATERANGE by tag position ends after its planned duration",
+			lines: []string{
+				"s",
+				`#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-05-01T18:00:10Z",PLANNED-DURATION=10,SCTE35-OUT=` + spliceOutHex,
+				"s", "s",
+			},
+			ads:     []int{1},
+			markers: map[int]string{1: "EXT-X-DATERANGE"},
+			planned: map[int]float64{1: 10},
+		},
+		{
+			name:  "DATERANGE without SCTE-35",
+			lines: []string{"s", `#EXT-X-DATERANGE:ID="chapter",START-DATE="2024-05-01T18:00:10Z",DURATION=10`, "s"},
+		},
+	}
+
+	for _, tt := range tests {
+		segments, err := ParseMediaPlaylist(adPlaylist(tt.lines...), "https://example.com/")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		var ads []int
+		for i, seg := range segments {
+			if seg.AdBreak {
+				ads = append(ads, i)
+			}
+			if seg.AdMarker != tt.markers[i] {
+				t.Errorf("%s: segment %d marker %q, want %q", tt.name, i, seg.AdMarker, tt.markers[i])
+			}
+			if seg.AdPlanned != tt.planned[i] {
+				t.Errorf("%s: segment %d planned %v, want %v", tt.name, i, seg.AdPlanned, tt.planned[i])
+			}
+		}
+		if !slices.Equal(ads, tt.ads) {
+			t.Errorf("%s: ad segments %v, want %v", tt.name, ads, tt.ads)
+		}
+	}
+}
+
+func TestAdPartStarts(t *testing.T) {
+	var segments []Segment
+	for seq := 10; seq < 20; seq++ {
+		segments = append(segments, Segment{Sequence: seq, Duration: 10})
+	}
+	for _, i := range []int{2, 3, 7} {
+		segments[i].AdBreak = true
+	}
+	segments[2].AdMarker = "EXT-X-CUE-OUT"
+
+	breaks := findAdBreaks(segments)
+	if len(breaks) != 2 || breaks[0].first != 12 || breaks[0].last != 13 || breaks[0].duration != 20 ||
+		breaks[1].first != 17 || breaks[1].last != 17 {
+		t.Fatalf("got breaks %+v, want 12-13 and 17", breaks)
+	}
+
+	d := &Downloader{adBreaks: breaks}
+	var kept []Segment
+	for _, seg := range segments {
+		if !seg.AdBreak {
+			kept = append(kept, seg)
+		}
+	}
+	var starts []int
+	for i, start := range d.adPartStarts(kept) {
+		if start {
+			starts = append(starts, kept[i].Sequence)
+		}
+	}
+	if want := []int{14, 18}; !slices.Equal(starts, want) {
+		t.Errorf("parts start at segments %v, want %v", starts, want)
+	}
+}
@@ -0,0 +1,365 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"m3u8-downloader/internal/config"
+	"m3u8-downloader/internal/mpegts"
+)
+
+// chapterMergeWindow is how close two chapter boundaries may be before they are merged
+const chapterMergeWindow = time.Second
+
+// chapter is a titled span of an output file
+type chapter struct {
+	start, end time.Duration
+	title      string
+	id, class  string // From EXT-X-DATERANGE
+}
+
+// chapterMark is a chapter boundary on the output timeline
+type chapterMark struct {
+	at        time.Duration
+	title     string
+	id, class string
+	closing   bool // End of a date range, replaced by any other boundary at the same point
+}
+
+// chaptersEnabled reports whether chapter markers were requested
+func (d *Downloader) chaptersEnabled() bool {
+	return len(d.config.Chapters) > 0 && d.config.Output != "-"
+}
+
+// outputOffsets returns where each segment starts in the output and the length of
+// the output. A first segment trimmed by a clip starts before zero.
+func (d *Downloader) outputOffsets(segments []Segment) ([]time.Duration, time.Duration) {
+	offsets := make([]time.Duration, len(segments))
+	var t time.Duration
+	if d.clip != nil && d.clip.head != mpegts.NoBound {
+		t = -d.clip.head
+	}
+	for i, seg := range segments {
+		offsets[i] = t
+		t += seconds(seg.Duration)
+	}
+	if d.clip != nil && d.clip.tail != mpegts.NoBound {
+		t -= seconds(segments[len(segments)-1].Duration) - d.clip.tail
+	}
+	return offsets, t
+}
+
+// chapterMarks collects the chapter boundaries of the requested sources. parsed is
+// the whole media playlist, segments the part of it written to the output.
+func (d *Downloader) chapterMarks(parsed, segments []Segment, offsets []time.Duration) []chapterMark {
+	// Marks collected first win when boundaries are merged, so date ranges,
+	// which carry their own titles, go before the other sources
+	var marks []chapterMark
+
+	if slices.Contains(d.config.Chapters, config.ChaptersDateRange) {
+		for _, seg := range parsed {
+			for _, r := range seg.DateRanges {
+				at, ok := dateRangeOffset(r.Start, seg.Sequence, segments, offsets)
+				if !ok {
+					continue
+				}
+
+				end, closed := time.Duration(0), false
+				if r.Duration > 0 {
+					end, closed = at+seconds(r.Duration), true
+					if !r.Start.IsZero() && !segments[0].ProgramDateTime.IsZero() {
+						end, closed = dateRangeOffset(r.Start.Add(seconds(r.Duration)), seg.Sequence, segments, offsets)
+					}
+					if closed && end <= 0 {
+						continue
+					}
+				}
+
+				title := r.Title
+				if title == "" {
+					title = r.ID
+				}
+				marks = append(marks, chapterMark{at: max(at, 0), title: title, id: r.ID, class: r.Class})
+				if closed {
+					marks = append(marks, chapterMark{at: end, closing: true})
+				}
+			}
+		}
+	}
+
+	if slices.Contains(d.config.Chapters, config.ChaptersDiscontinuity) {
+		for i := 1; i < len(segments); i++ {
+			if segments[i].Discontinuity {
+				marks = append(marks, chapterMark{at: offsets[i]})
+			}
+		}
+	}
+
+	if slices.Contains(d.config.Chapters, config.ChaptersPDT) {
+		jumps := 0
+		for i := 1; i < len(segments); i++ {
+			if dateTimeJump(segments[i-1], segments[i]) {
+				marks = append(marks, chapterMark{at: offsets[i], title: wallTitle(segments[i].ProgramDateTime)})
+				jumps++
+			}
+		}
+		if jumps > 0 {
+			marks = append(marks, chapterMark{title: wallTitle(segments[0].ProgramDateTime)})
+		}
+	}
+
+	return marks
+}
+
+// dateRangeOffset places a date on the output timeline using the program date-times,
+// or the date range tagged before segment sequence when there are none
+func dateRangeOffset(date time.Time, sequence int, segments []Segment, offsets []time.Duration) (time.Duration, bool) {
+	if date.IsZero() || segments[0].ProgramDateTime.IsZero() {
+		for i, seg := range segments {
+			if seg.Sequence >= sequence {
+				return offsets[i], true
+			}
+		}
+		return 0, false
+	}
+
+	// The last segment starting at or before the date, without running into a gap
+	// left by segments missing from the output
+	i := -1
+	for j, seg := range segments {
+		if !seg.ProgramDateTime.After(date) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return offsets[0] + date.Sub(segments[0].ProgramDateTime), true
+	}
+	into := date.Sub(segments[i].ProgramDateTime)
+	if length := seconds(segments[i].Duration); into > length {
+		if i == len(segments)-1 {
+			return 0, false
+		}
+		into = length
+	}
+	return offsets[i] + into, true
+}
+
+// wallTitle names a chapter after its wall-clock start
+func wallTitle(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// buildChapters turns boundaries into consecutive chapters covering [0, total)
+func buildChapters(marks []chapterMark, total time.Duration) []chapter {
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].at < marks[j].at })
+
+	var merged []chapterMark
+	for _, m := range marks {
+		if m.at < 0 || m.at >= total {
+			continue
+		}
+		if n := len(merged); n > 0 && m.at-merged[n-1].at < chapterMergeWindow {
+			// Keep the earlier position and the more specific title
+			if last := &merged[n-1]; !m.closing && (last.closing || last.title == "") {
+				at := last.at
+				*last = m
+				last.at = at
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	if len(merged) == 0 || merged[0].at >= chapterMergeWindow {
+		merged = append([]chapterMark{{}}, merged...)
+	}
+	merged[0].at = 0
+
+	chapters := make([]chapter, len(merged))
+	for i, m := range merged {
+		end := total
+		if i+1 < len(merged) {
+			end = merged[i+1].at
+		}
+		title := m.title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters[i] = chapter{start: m.at, end: end, title: title, id: m.id, class: m.class}
+	}
+	return chapters
+}
+
+// sliceChapters returns the chapters overlapping [from, to), rebased to start at from
+func sliceChapters(chapters []chapter, from, to time.Duration) []chapter {
+	var out []chapter
+	for _, c := range chapters {
+		start, end := max(c.start, from), min(c.end, to)
+		if end-start < chapterMergeWindow {
+			continue
+		}
+		c.start, c.end = start-from, end-from
+		out = append(out, c)
+	}
+
+	// Slivers cut off at the part boundaries go to their neighbours
+	for i := range out {
+		if i == 0 {
+			out[i].start = 0
+		} else {
+			out[i-1].end = out[i].start
+		}
+	}
+	if len(out) > 0 {
+		out[len(out)-1].end = to - from
+	}
+	return out
+}
+
+// writeChapters writes a chapter sidecar for each output file and embeds the
+// chapters into MP4 and MKV outputs
+func (d *Downloader) writeChapters(parsed, segments []Segment) error {
+	offsets, total := d.outputOffsets(segments)
+	marks := d.chapterMarks(parsed, segments, offsets)
+	if len(marks) == 0 {
+		d.println("No chapter markers found")
+		return nil
+	}
+	chapters := buildChapters(marks, total)
+
+	// Each part of a split output gets the chapters of its own time span
+	from := time.Duration(0)
+	for n, name := range d.merger.done {
+		to := total
+		if n+1 < len(d.merger.firsts) {
+			to = offsets[d.merger.firsts[n+1]]
+		}
+		if err := d.writeChapterFile(name, sliceChapters(chapters, from, to)); err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// writeChapterFile writes the sidecar of one output file
+func (d *Downloader) writeChapterFile(output string, chapters []chapter) error {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+
+	ffmetadata := base + ".ffmetadata"
+	sidecar := ffmetadata
+	if d.config.ChapterFormat == config.ChapterFormatJSON {
+		sidecar = base + ".chapters.json"
+		if err := writeChaptersJSON(sidecar, chapters); err != nil {
+			return err
+		}
+		// ffmpeg reads chapters from FFmetadata only
+		ffmetadata = filepath.Join(d.job.dir, filepath.Base(base)+".ffmetadata")
+		defer os.Remove(ffmetadata)
+	}
+	if err := writeFFMetadata(ffmetadata, chapters); err != nil {
+		return err
+	}
+	d.printf("Wrote %d chapters to %s\n", len(chapters), sidecar)
+
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp4", ".m4v", ".mov", ".mkv":
+		d.embedChapters(output, ffmetadata)
+	}
+	return nil
+}
+
+// writeFFMetadata writes chapters in ffmpeg's FFMETADATA1 format
+func writeFFMetadata(name string, chapters []chapter) error {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			c.start.Milliseconds(), c.end.Milliseconds(), escapeFFMetadata(c.title))
+	}
+	if err := os.WriteFile(name, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write chapters: %w", err)
+	}
+	return nil
+}
+
+// escapeFFMetadata escapes the characters FFMETADATA1 treats as special
+func escapeFFMetadata(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// chapterJSON is a chapter in the JSON sidecar, times in seconds
+type chapterJSON struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+	ID    string  `json:"id,omitempty"`
+	Class string  `json:"class,omitempty"`
+}
+
+// writeChaptersJSON writes chapters as a JSON document
+func writeChaptersJSON(name string, chapters []chapter) error {
+	doc := struct {
+		Chapters []chapterJSON `json:"chapters"`
+	}{Chapters: make([]chapterJSON, len(chapters))}
+	for i, c := range chapters {
+		doc.Chapters[i] = chapterJSON{
+			Start: float64(c.start.Milliseconds()) / 1000,
+			End:   float64(c.end.Milliseconds()) / 1000,
+			Title: c.title,
+			ID:    c.id,
+			Class: c.class,
+		}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write chapters: %w", err)
+	}
+	return nil
+}
+
+// embedChapters remuxes the output into the container named by its extension with
+// the chapters attached. This needs ffmpeg; without it, or when the remux fails, the
+// output stays as downloaded and the sidecar is all there is.
+func (d *Downloader) embedChapters(output, ffmetadata string) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		d.printf("ffmpeg not found, chapters not embedded in %s\n", output)
+		return
+	}
+
+	ext := filepath.Ext(output)
+	temp := strings.TrimSuffix(output, ext) + ".chapters" + ext
+	cmd := exec.Command(ffmpeg, "-v", "error", "-y", "-i", output, "-i", ffmetadata,
+		"-map", "0", "-map_metadata", "1", "-map_chapters", "1", "-c", "copy", temp)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(temp)
+		d.printf("ffmpeg failed, chapters not embedded in %s: %v: %s\n", output, err, strings.TrimSpace(string(out)))
+		return
+	}
+	if err := os.Rename(temp, output); err != nil {
+		os.Remove(temp)
+		d.printf("Could not replace %s with the remuxed output, chapters not embedded: %v\n", output, err)
+		return
+	}
+
+	d.printf("Embedded chapters in %s\n", output)
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+// sameChapters compares start, end and title of two chapter lists
+func sameChapters(got, want []chapter) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].start != want[i].start || got[i].end != want[i].end || got[i].title != want[i].title {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildChapters(t *testing.T) {
+	tests := []struct {
+		name  string
+		marks []chapterMark
+		want  []chapter
+	}{
+		{
+			name: "no marks",
+			want: []chapter{{start: 0, end: seconds(60), title: "Chapter 1"}},
+		},
+		{
+			name:  "untitled opening chapter",
+			marks: []chapterMark{{at: seconds(30), title: "B"}, {at: seconds(10), title: "A"}},
+			want: []chapter{
+				{start: 0, end: seconds(10), title: "Chapter 1"},
+				{start: seconds(10), end: seconds(30), title: "A"},
+				{start: seconds(30), end: seconds(60), title: "B"},
+			},
+		},
+		{
+			name:  "mark near the start opens the first chapter",
+			marks: []chapterMark{{at: seconds(0.5), title: "Intro"}, {at: seconds(20), title: "X"}},
+			want: []chapter{
+				{start: 0, end: seconds(20), title: "Intro"},
+				{start: seconds(20), end: seconds(60), title: "X"},
+			},
+		},
+		{
+			name:  "marks outside the output are dropped",
+			marks: []chapterMark{{at: seconds(-5), title: "Before"}, {at: seconds(20), title: "X"}, {at: seconds(60), title: "End"}, {at: seconds(70), title: "After"}},
+			want: []chapter{
+				{start: 0, end: seconds(20), title: "Chapter 1"},
+				{start: seconds(20), end: seconds(60), title: "X"},
+			},
+		},
+		{
+			name: "marks within the merge window",
+			marks: []chapterMark{
+				{at: seconds(10)}, {at: seconds(10.4), title: "Ad"}, // Untitled takes the title
+				{at: seconds(20), title: "A"}, {at: seconds(20.5), title: "B"}, // First title wins
+				{at: seconds(30), closing: true}, {at: seconds(30.3), title: "C"}, // Closing mark is replaced
+				{at: seconds(40), title: "D"}, {at: seconds(40.2), closing: true}, // Closing mark is dropped
+			},
+			want: []chapter{
+				{start: 0, end: seconds(10), title: "Chapter 1"},
+				{start: seconds(10), end: seconds(20), title: "Ad"},
+				{start: seconds(20), end: seconds(30), title: "A"},
+				{start: seconds(30), end: seconds(40), title: "C"},
+				{start: seconds(40), end: seconds(60), title: "D"},
+			},
+		},
+		{
+			name:  "untitled chapters are numbered",
+			marks: []chapterMark{{at: 0}, {at: seconds(15)}, {at: seconds(40), title: "Named"}},
+			want: []chapter{
+				{start: 0, end: seconds(15), title: "Chapter 1"},
+				{start: seconds(15), end: seconds(40), title: "Chapter 2"},
+				{start: seconds(40), end: seconds(60), title: "Named"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := buildChapters(tt.marks, seconds(60)); !sameChapters(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSliceChapters(t *testing.T) {
+	chapters := []chapter{
+		{start: 0, end: seconds(10), title: "A"},
+		{start: seconds(10), end: seconds(30), title: "B"},
+		{start: seconds(30), end: seconds(60), title: "C"},
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Duration
+		want     []chapter
+	}{
+		{
+			name: "whole output",
+			from: 0, to: seconds(60),
+			want: chapters,
+		},
+		{
+			name: "middle part is rebased",
+			from: seconds(20), to: seconds(45),
+			want: []chapter{
+				{start: 0, end: seconds(10), title: "B"},
+				{start: seconds(10), end: seconds(25), title: "C"},
+			},
+		},
+		{
+			name: "sliver at the start goes to the next chapter",
+			from: seconds(9.5), to: seconds(40),
+			want: []chapter{
+				{start: 0, end: seconds(20.5), title: "B"},
+				{start: seconds(20.5), end: seconds(30.5), title: "C"},
+			},
+		},
+		{
+			name: "sliver at the end goes to the previous chapter",
+			from: 0, to: seconds(30.5),
+			want: []chapter{
+				{start: 0, end: seconds(10), title: "A"},
+				{start: seconds(10), end: seconds(30.5), title: "B"},
+			},
+		},
+		{
+			name: "beyond the chapters",
+			from: seconds(60), to: seconds(70),
+		},
+	}
+
+	for _, tt := range tests {
+		if got := sliceChapters(chapters, tt.from, tt.to); !sameChapters(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// chunkable reports whether the segment answered by resp is split into byte
+// ranges fetched in parallel
+func (d *Downloader) chunkable(resp *http.Response) bool {
+	return d.config.ChunkThreshold > 0 && resp.ContentLength >= d.config.ChunkThreshold &&
+		resp.ContentLength > d.config.ChunkSize && resp.Header.Get("Accept-Ranges") == "bytes"
+}
+
+// downloadChunked saves a large segment to fileName in byte ranges of ChunkSize.
+// The first chunk is read from body, the response already open, with its errors
+// passed through firstErr; the others are shared out between the segment's own
+// worker and extra workers taken from the pool while there are chunks left, so a
+// segment never waits for the pool.
+func (d *Downloader) downloadChunked(ctx context.Context, url, fileName string, resp *http.Response, body io.Reader, firstErr func(error) error) (*segmentData, error) {
+	total, size := resp.ContentLength, d.config.ChunkSize
+	chunks := int((total + size - 1) / size)
+	validator := rangeValidator(resp)
+	host := hostOf(url)
+
+	tempFileName := fileName + ".tmp"
+	out, err := os.Create(tempFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var failed error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed == nil {
+			failed = err
+			cancel()
+		}
+	}
+
+	next := make(chan int64, chunks-1)
+	for start := size; start < total; start += size {
+		next <- start
+	}
+	close(next)
+
+	// Takes chunks until none are left. A chunk is retried on its own, so a broken
+	// connection does not cost the whole segment.
+	work := func() {
+		for start := range next {
+			end := min(start+size, total)
+			err := d.retry.Do(ctx, func() error {
+				return d.downloadChunk(ctx, url, validator, out, start, end)
+			}, nil)
+			if err != nil {
+				fail(fmt.Errorf("chunk at byte %d: %w", start, err))
+				return
+			}
+		}
+	}
+
+	// Helpers stop waiting for a worker once every chunk has been taken
+	helping, stopHelping := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 1; i < chunks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d.workers.acquire(helping) != nil {
+				return
+			}
+			defer d.workers.release()
+			if d.hosts.acquire(helping, host) != nil {
+				return
+			}
+			defer d.hosts.release(host)
+			work()
+		}()
+	}
+
+	first := min(size, total)
+	n, err := io.Copy(io.NewOffsetWriter(out, 0), io.LimitReader(body, first))
+	if err == nil && n < first {
+		err = io.ErrUnexpectedEOF
+	}
+	resp.Body.Close()
+	if err = firstErr(err); err != nil && utils.IsRetryable(err) {
+		// Fetched again as a range, the other chunks carry on meanwhile
+		err = d.retry.Do(ctx, func() error {
+			return d.downloadChunk(ctx, url, validator, out, 0, first)
+		}, nil)
+	}
+	if err != nil {
+		fail(fmt.Errorf("chunk at byte 0: %w", err))
+	} else {
+		work()
+	}
+	stopHelping()
+	wg.Wait()
+
+	if err := out.Close(); err != nil {
+		fail(err)
+	}
+	if failed != nil {
+		os.Remove(tempFileName)
+		return nil, failed
+	}
+
+	if err := os.Rename(tempFileName, fileName); err != nil {
+		os.Remove(tempFileName)
+		return nil, err
+	}
+	return &segmentData{file: fileName, size: total}, nil
+}
+
+// downloadChunk writes the byte range [start, end) of url at the same offset of out
+func (d *Downloader) downloadChunk(ctx context.Context, url, validator string, out *os.File, start, end int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timeout := newRequestTimeout(d.config.Timeout, cancel)
+	defer timeout.stop()
+
+	req, err := d.client.NewRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return timeout.err(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && resumeOffset(resp) == start:
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent:
+		// If-Range sends the whole segment when it changed since the first chunk
+		return fmt.Errorf("server did not send the requested range (HTTP status code: %d), the segment may have changed", resp.StatusCode)
+	default:
+		return utils.NewHTTPError(resp)
+	}
+
+	watch := d.watchTransfer(cancel)
+	defer watch.stop()
+	if watch != nil {
+		// The stall checks take over, however long a large body takes
+		timeout.stop()
+	}
+	body := d.limitBody(ctx, resp.Body, timeout, watch)
+
+	n, err := io.Copy(io.NewOffsetWriter(out, start), io.LimitReader(body, end-start))
+	if err == nil && n < end-start {
+		err = io.ErrUnexpectedEOF
+	}
+	return diskFull(watch.err(timeout.err(err)))
+}
@@ -0,0 +1,49 @@
+package downloader
+
+import (
+	"net/url"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// newClient builds the HTTP client shared by playlist and segment requests
+func (d *Downloader) newClient() (*utils.Client, error) {
+	headers := append([]utils.Header(nil), d.config.Headers...)
+
+	// Credentials only go to the playlist host unless told otherwise
+	authHosts := d.config.AuthHosts
+	if len(authHosts) == 0 {
+		if u, err := url.Parse(d.config.URL); err == nil && u.Hostname() != "" {
+			authHosts = []string{u.Hostname()}
+		}
+	}
+
+	if d.config.BasicAuth != "" {
+		headers = append(headers, utils.BasicAuthHeader(d.config.BasicAuth, authHosts))
+	}
+	if d.config.BearerToken != "" {
+		headers = append(headers, utils.BearerAuthHeader(d.config.BearerToken, authHosts))
+	}
+
+	return utils.NewClient(utils.ClientOptions{
+		UserAgent:        d.config.UserAgent,
+		Headers:          headers,
+		CookieFile:       d.config.CookieFile,
+		MaxConns:         d.maxThreads(),
+		ConnectTimeout:   d.config.ConnectTimeout,
+		FirstByteTimeout: d.config.FirstByteTimeout,
+		Proxy: utils.ProxyOptions{
+			URL:     d.config.Proxy,
+			NoProxy: d.config.NoProxy,
+			Rules:   d.config.ProxyRules,
+		},
+		TLS: utils.TLSOptions{
+			CAFile:      d.config.CAFile,
+			ClientCerts: d.config.ClientCerts,
+			MinVersion:  d.config.TLSMinVersion,
+			ServerNames: d.config.ServerNames,
+			Pins:        d.config.Pins,
+			Insecure:    d.config.Insecure,
+		},
+	})
+}
@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"m3u8-downloader/internal/mpegts"
+	"m3u8-downloader/pkg/utils"
+)
+
+// clipPlan describes how the first and last selected segments are trimmed
+type clipPlan struct {
+	last int           // Index of the last selected segment
+	head time.Duration // Offset into the first segment where the clip starts, NoBound for none
+	tail time.Duration // Offset into the last segment where the clip ends, NoBound for none
+}
+
+// clipping reports whether a time range was requested
+func (d *Downloader) clipping() bool {
+	return d.config.ClipStart != nil || d.config.ClipEnd != nil || d.config.ClipDuration > 0
+}
+
+// clipSegments selects the segments overlapping the requested time range using the
+// EXTINF durations and plans the keyframe trim of the segments at either end
+func (d *Downloader) clipSegments(segments []Segment) ([]Segment, *clipPlan, error) {
+	// Offsets of each segment from the start of the playlist
+	starts := make([]time.Duration, len(segments))
+	var total time.Duration
+	for i, seg := range segments {
+		starts[i] = total
+		total += seconds(seg.Duration)
+	}
+
+	start := time.Duration(0)
+	if d.config.ClipStart != nil {
+		var err error
+		start, err = clipOffset(*d.config.ClipStart, segments, starts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+
+	end := total
+	switch {
+	case d.config.ClipEnd != nil:
+		var err error
+		end, err = clipOffset(*d.config.ClipEnd, segments, starts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid end: %w", err)
+		}
+	case d.config.ClipDuration > 0:
+		end = start + d.config.ClipDuration
+	}
+
+	if start >= total {
+		return nil, nil, fmt.Errorf("start %s is beyond the end of the playlist (%s)",
+			utils.FormatOffset(start), utils.FormatOffset(total))
+	}
+	if end <= start {
+		return nil, nil, fmt.Errorf("end %s is not after start %s",
+			utils.FormatOffset(end), utils.FormatOffset(start))
+	}
+	if end > total {
+		end = total
+	}
+
+	first, last := -1, -1
+	for i, seg := range segments {
+		if starts[i]+seconds(seg.Duration) > start && starts[i] < end {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil, nil, fmt.Errorf("no segments between %s and %s",
+			utils.FormatOffset(start), utils.FormatOffset(end))
+	}
+
+	plan := &clipPlan{last: last - first, head: mpegts.NoBound, tail: mpegts.NoBound}
+	if head := start - starts[first]; head > 0 {
+		plan.head = head
+	}
+	if tail := end - starts[last]; tail < seconds(segments[last].Duration) {
+		plan.tail = tail
+	}
+
+	d.printf("Clipping %s to %s: segments %d-%d of %d\n",
+		utils.FormatOffset(start), utils.FormatOffset(end), first+1, last+1, len(segments))
+
+	return segments[first : last+1], plan, nil
+}
+
+// clipOffset converts a time point to an offset from the start of the playlist
+func clipOffset(t utils.TimePoint, segments []Segment, starts []time.Duration) (time.Duration, error) {
+	if !t.IsWall() {
+		return t.Offset, nil
+	}
+
+	if segments[0].ProgramDateTime.IsZero() {
+		if value := segments[0].InvalidDateTime; value != "" {
+			return 0, fmt.Errorf("wall-clock time %s needs EXT-X-PROGRAM-DATE-TIME, but %q is invalid", t, value)
+		}
+		return 0, fmt.Errorf("wall-clock time %s needs EXT-X-PROGRAM-DATE-TIME in the playlist", t)
+	}
+
+	// Use the last segment starting at or before the time, so gaps in the
+	// program date-time do not shift the offset
+	i := 0
+	for j, seg := range segments {
+		if !seg.ProgramDateTime.After(t.Wall) {
+			i = j
+		}
+	}
+
+	// The date-time is inferred from the last valid tag, which an invalid tag since may have moved
+	for j := i; j >= 0; j-- {
+		if segments[j].InvalidDateTime != "" {
+			return 0, fmt.Errorf("wall-clock time %s falls after the invalid EXT-X-PROGRAM-DATE-TIME %q of segment %d",
+				t, segments[j].InvalidDateTime, segments[j].Sequence)
+		}
+		if segments[j].ExplicitDateTime {
+			break
+		}
+	}
+
+	offset := starts[i] + t.Wall.Sub(segments[i].ProgramDateTime)
+	if offset < 0 {
+		offset = 0
+	}
+	return offset, nil
+}
+
+// transform writes segment i to w, trimming the ends of the clip at keyframes.
+// Segments that are not MPEG-TS are kept whole.
+func (c *clipPlan) transform(i int, seg *segmentData, w io.Writer, buffer []byte) error {
+	start, end := mpegts.NoBound, mpegts.NoBound
+	if i == 0 {
+		start = c.head
+	}
+	if i == c.last {
+		end = c.tail
+	}
+
+	if start != mpegts.NoBound || end != mpegts.NoBound {
+		err := mpegts.Trim(seg.open, w, start, end)
+		if !errors.Is(err, mpegts.ErrSync) {
+			return err
+		}
+	}
+
+	return copySegment(seg, w, buffer)
+}
+
+// seconds converts an EXTINF duration to a time.Duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,276 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+const (
+	adaptiveMinRound    = 4    // Fewest requests measured before the worker count is adjusted
+	adaptiveGain        = 1.05 // Throughput growth that makes another worker worth it
+	adaptiveLatencyRise = 1.25 // Latency growth that, without more throughput, means the link is saturated
+	adaptiveErrorRate   = 0.1  // Share of failed requests that halves the worker count
+)
+
+// workerPool bounds the segment requests in flight. Its size can change while
+// requests run; above a lowered size, new requests wait until enough finish.
+type workerPool struct {
+	mu      sync.Mutex
+	size    int
+	active  int
+	changed chan struct{} // Closed and replaced when a request ends or the size changes
+}
+
+// newWorkerPool creates a pool running size requests at once
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{size: size, changed: make(chan struct{})}
+}
+
+// acquire waits for a free worker or until ctx is done
+func (p *workerPool) acquire(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		if p.active < p.size {
+			p.active++
+			p.mu.Unlock()
+			return nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the worker taken by acquire
+func (p *workerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active--
+	p.notify()
+}
+
+// getSize returns the number of requests allowed at once
+func (p *workerPool) getSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// resize changes the number of requests allowed at once
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = size
+	p.notify()
+}
+
+// notify wakes every waiting acquire, the caller holds mu
+func (p *workerPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// concurrencyController sizes a workerPool from the outcome of its requests,
+// AIMD style. After each round, about one request per worker, the pool grows by
+// one worker. It is halved when servers answer 429 or 503 or too many requests
+// fail, and shrinks by one when latency has risen over the best round without a
+// gain in throughput.
+type concurrencyController struct {
+	pool     *workerPool
+	min, max int
+	printf   func(format string, args ...any)
+
+	mu         sync.Mutex
+	roundStart time.Time
+	requests   int // Requests finished in the current round
+	failures   int // Of which failed with a retryable error
+	throttled  int // Of which were answered with 429 or 503
+	bytes      int64
+	latency    time.Duration // Summed over the successful requests
+	best       roundStats    // The round with the highest throughput since the pool was last halved
+
+	// Summary of the levels chosen
+	initial, low, high int
+	raised             int
+	lowered            map[string]int // By reason
+	levelSince         time.Time
+	workerTime         float64 // Worker-seconds, for the average level
+	started            time.Time
+}
+
+// roundStats are the measurements of one round
+type roundStats struct {
+	throughput float64 // Bytes per second
+	latency    time.Duration
+}
+
+// newConcurrencyController adapts pool between min and max workers
+func newConcurrencyController(pool *workerPool, min, max int, printf func(format string, args ...any)) *concurrencyController {
+	now := time.Now()
+	size := pool.getSize()
+	return &concurrencyController{
+		pool:       pool,
+		min:        min,
+		max:        max,
+		printf:     printf,
+		roundStart: now,
+		initial:    size,
+		low:        size,
+		high:       size,
+		lowered:    make(map[string]int),
+		levelSince: now,
+		started:    now,
+	}
+}
+
+// record adds the outcome of a segment request that took elapsed
+func (c *concurrencyController) record(data *segmentData, elapsed time.Duration, err error) {
+	if c == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests++
+	var httpErr *utils.HTTPError
+	switch {
+	case err == nil:
+		c.bytes += data.size
+		c.latency += elapsed
+	case errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode == http.StatusServiceUnavailable):
+		c.throttled++
+	case utils.IsRetryable(err):
+		c.failures++
+	}
+
+	if c.requests >= max(c.pool.getSize(), adaptiveMinRound) {
+		c.adjust()
+	}
+}
+
+// adjust picks the worker count for the next round, the caller holds mu
+func (c *concurrencyController) adjust() {
+	now := time.Now()
+	level := c.pool.getSize()
+
+	round := roundStats{}
+	if elapsed := now.Sub(c.roundStart).Seconds(); elapsed > 0 {
+		round.throughput = float64(c.bytes) / elapsed
+	}
+	if ok := c.requests - c.failures - c.throttled; ok > 0 {
+		round.latency = c.latency / time.Duration(ok)
+	}
+
+	next, reason := level+1, ""
+	switch {
+	case c.throttled > 0:
+		next, reason = level/2, "throttled"
+	case float64(c.failures) > float64(c.requests)*adaptiveErrorRate:
+		next, reason = level/2, "errors"
+	case c.best.throughput > 0 && round.throughput < c.best.throughput*adaptiveGain &&
+		float64(round.latency) > float64(c.best.latency)*adaptiveLatencyRise:
+		next, reason = level-1, "latency"
+	}
+	next = min(max(next, c.min), c.max)
+
+	switch {
+	case next > level:
+		c.raised++
+	case next < level:
+		// Steps back from saturation are routine, halvings are worth a message
+		c.lowered[reason]++
+		if reason != "latency" {
+			c.printf("\nConcurrency lowered to %d (%s)\n", next, describeReason(reason, c.throttled, c.failures, c.requests))
+		}
+	}
+	if next != level {
+		c.setLevel(level, next, now)
+	}
+
+	switch {
+	case reason == "throttled" || reason == "errors":
+		// The server changed its mind, earlier rounds say little about the next
+		c.best = roundStats{}
+	case round.throughput > c.best.throughput:
+		c.best = round
+	}
+	c.roundStart = now
+	c.requests, c.failures, c.throttled = 0, 0, 0
+	c.bytes, c.latency = 0, 0
+}
+
+// setLevel resizes the pool, keeping the statistics for the summary
+func (c *concurrencyController) setLevel(level, next int, now time.Time) {
+	c.workerTime += float64(level) * now.Sub(c.levelSince).Seconds()
+	c.levelSince = now
+	c.low = min(c.low, next)
+	c.high = max(c.high, next)
+	c.pool.resize(next)
+}
+
+// describeReason explains why the worker count was halved
+func describeReason(reason string, throttled, failures, requests int) string {
+	if reason == "throttled" {
+		return fmt.Sprintf("%d of %d requests answered with 429 or 503", throttled, requests)
+	}
+	return fmt.Sprintf("%d of %d requests failed", failures, requests)
+}
+
+// summary describes the worker counts chosen during the download
+func (c *concurrencyController) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	level := c.pool.getSize()
+	average := float64(level)
+	if total := now.Sub(c.started).Seconds(); total > 0 {
+		average = (c.workerTime + float64(level)*now.Sub(c.levelSince).Seconds()) / total
+	}
+
+	s := fmt.Sprintf("Adaptive concurrency (%d-%d): started at %d, ended at %d, ranged %d-%d, average %.1f; raised %d times",
+		c.min, c.max, c.initial, level, c.low, c.high, average, c.raised)
+	var reasons []string
+	lowered := 0
+	for _, reason := range []string{"throttled", "errors", "latency"} {
+		if n := c.lowered[reason]; n > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+			lowered += n
+		}
+	}
+	s += fmt.Sprintf(", lowered %d times", lowered)
+	if len(reasons) > 0 {
+		s += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	return s
+}
+
+// maxThreads is the most segment requests that may run at once
+func (d *Downloader) maxThreads() int {
+	if d.config.Adaptive {
+		return d.config.MaxThreads
+	}
+	return d.config.Threads
+}
+
+// printConcurrency reports the worker counts chosen by adaptive concurrency
+func (d *Downloader) printConcurrency() {
+	if d.adaptive != nil {
+		d.println(d.adaptive.summary())
+	}
+}
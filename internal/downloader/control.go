@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// controlLimits is the JSON document served by the control API
+type controlLimits struct {
+	Rate            int64 `json:"rate"`             // Bytes per second, 0 when unlimited
+	HostConnections int   `json:"host_connections"` // Requests per host, 0 when unlimited
+}
+
+// startControl serves the control API on addr until ctx is done:
+//
+//	GET  /limits                                  current limits as JSON
+//	POST /limits?rate=2M&host-connections=4       change them; rate=0 lifts the limit
+//
+// Without a control token the API only listens on loopback addresses.
+func (d *Downloader) startControl(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tcp, ok := listener.Addr().(*net.TCPAddr); d.config.ControlToken == "" && !(ok && tcp.IP.IsLoopback()) {
+		listener.Close()
+		return fmt.Errorf("%s is reachable from other hosts, set -control-token or listen on a loopback address such as 127.0.0.1", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/limits", d.handleLimits)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	d.printf("Control API listening on http://%s/limits\n", listener.Addr())
+	return nil
+}
+
+// handleLimits reports or changes the limits of the running download
+func (d *Downloader) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if status, err := d.authorizeControl(r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		if err := d.applyLimits(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(controlLimits{Rate: d.rate.Rate(), HostConnections: d.hosts.getLimit()})
+}
+
+// authorizeControl checks the control token when one is set. Without a token,
+// changes need a JSON content type, which browsers do not send cross-site without
+// asking first, so a web page cannot change the limits through a form.
+func (d *Downloader) authorizeControl(r *http.Request) (int, error) {
+	if token := d.config.ControlToken; token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return http.StatusUnauthorized, errors.New("missing or wrong control token")
+		}
+		return 0, nil
+	}
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, errors.New("changes must be sent with Content-Type: application/json")
+		}
+	}
+	return 0, nil
+}
+
+// applyLimits changes the limits given as query values, or form values when the
+// control token is set, all or none of them
+func (d *Downloader) applyLimits(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	rate, setRate := int64(0), r.Form.Has("rate")
+	if setRate {
+		var err error
+		if rate, err = parseRate(r.Form.Get("rate")); err != nil {
+			return err
+		}
+	}
+
+	hosts, setHosts := 0, r.Form.Has("host-connections")
+	if setHosts {
+		var err error
+		hosts, err = strconv.Atoi(r.Form.Get("host-connections"))
+		if err != nil || hosts < 0 {
+			return fmt.Errorf("invalid host-connections %q", r.Form.Get("host-connections"))
+		}
+	}
+
+	if !setRate && !setHosts {
+		return errors.New("nothing to change, expected rate or host-connections")
+	}
+	if setRate {
+		d.setRateLimit(rate)
+	}
+	if setHosts {
+		d.setHostConnections(hosts)
+	}
+	return nil
+}
+
+// parseRate parses a bandwidth limit such as "2M", with "0" or "off" for no limit
+func parseRate(s string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "0", "off", "unlimited":
+		return 0, nil
+	}
+	rate, err := utils.ParseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return rate, nil
+}
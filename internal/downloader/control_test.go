@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"m3u8-downloader/internal/config"
+)
+
+func TestAuthorizeControl(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		method      string
+		contentType string
+		auth        string
+		status      int // Zero when allowed
+	}{
+		{name: "read", method: http.MethodGet},
+		{name: "JSON change", method: http.MethodPost, contentType: "application/json; charset=utf-8"},
+		{name: "form change", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", status: http.StatusUnsupportedMediaType},
+		{name: "plain text change", method: http.MethodPost, contentType: "text/plain", status: http.StatusUnsupportedMediaType},
+		{name: "change without content type", method: http.MethodPut, status: http.StatusUnsupportedMediaType},
+
+		{name: "token", token: "s3cret", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", auth: "Bearer s3cret"},
+		{name: "read without token", token: "s3cret", method: http.MethodGet, status: http.StatusUnauthorized},
+		{name: "JSON change without token", token: "s3cret", method: http.MethodPost, contentType: "application/json", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", method: http.MethodGet, auth: "Bearer s3cre", status: http.StatusUnauthorized},
+		{name: "token without scheme", token: "s3cret", method: http.MethodGet, auth: "s3cret", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		d := &Downloader{config: &config.Config{ControlToken: tt.token}}
+		r := httptest.NewRequest(tt.method, "/limits?rate=1M", nil)
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+
+		status, err := d.authorizeControl(r)
+		if tt.status == 0 && err != nil {
+			t.Errorf("%s: refused with %d %v", tt.name, status, err)
+		} else if tt.status != 0 && (err == nil || status != tt.status) {
+			t.Errorf("%s: got %d %v, want %d", tt.name, status, err, tt.status)
+		}
+	}
+}
+
+func TestStartControlLoopback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &Downloader{config: &config.Config{}, log: io.Discard}
+	if err := d.startControl(ctx, ":0"); err == nil {
+		t.Error("listening on every interface without a token was allowed")
+	}
+	if err := d.startControl(ctx, "127.0.0.1:0"); err != nil {
+		t.Errorf("loopback address refused: %v", err)
+	}
+
+	d.config.ControlToken = "s3cret"
+	if err := d.startControl(ctx, ":0"); err != nil {
+		t.Errorf("every interface with a token refused: %v", err)
+	}
+}
@@ -3,18 +3,21 @@ package downloader
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"m3u8-downloader/internal/config"
-	"m3u8-downloader/internal/validator"
+	"m3u8-downloader/internal/mpegts"
 	"m3u8-downloader/pkg/utils"
+	"m3u8-downloader/pkg/validator"
 )
 
 const (
@@ -24,59 +27,181 @@ const (
 
 // Downloader handles M3U8 playlist downloading and processing
 type Downloader struct {
-	config *config.Config
+	config    *config.Config
+	client    *utils.Client
+	retry     utils.RetryPolicy
+	source    *playlistSource
+	merger    *mergeWriter
+	clip      *clipPlan              // Trim of the clip ends, nil when the whole playlist is downloaded
+	timing    *timingReport          // Measured segment durations, nil without validation
+	adBreaks  []adBreak              // Ad breaks left out of the output
+	variant   *Variant               // Stream picked from the master playlist, nil for a media playlist
+	job       *job                   // Working directory of this download inside -dir
+	rate      *utils.RateLimiter     // Bandwidth limit shared by every request
+	hosts     *hostLimiter           // Concurrent segment requests per host
+	adaptive  *concurrencyController // Adjusts the number of workers, nil with fixed -threads
+	workers   *workerPool            // Segment and chunk requests in flight
+	hedge     *hedger                // Second requests for slow segments, nil when hedging is off
+	partialMu sync.Mutex
+	partials  map[string]*partialSegment // Starts of segments saved by broken-off transfers, by file name
+	space     *spaceGuard                // Low disk space protection, nil when disabled
+	started   time.Time                  // When the download began
+	sourceURL string                     // Playlist URL given by the user
+	unknown   sync.Once                  // Reports the first segment no validator recognizes
+	log       io.Writer                  // Human-readable progress, stderr when the output goes to stdout
 }
 
+// ErrBrokenPipe is returned when the reader of a stdout output goes away
+var ErrBrokenPipe = errors.New("output pipe closed by reader")
+
 // New creates a new Downloader instance
 func New(cfg *config.Config) *Downloader {
-	return &Downloader{config: cfg}
+	d := &Downloader{config: cfg, log: os.Stdout}
+	if cfg.Output == "-" {
+		d.log = os.Stderr
+	}
+	return d
+}
+
+// printf writes a progress message
+func (d *Downloader) printf(format string, args ...any) {
+	fmt.Fprintf(d.log, format, args...)
+}
+
+// println writes a progress line
+func (d *Downloader) println(args ...any) {
+	fmt.Fprintln(d.log, args...)
 }
 
 // Download starts the M3U8 download process
 func (d *Downloader) Download() error {
-	fmt.Println("Starting M3U8 downloader...")
-	fmt.Printf("URL: %s\n", d.config.URL)
-	fmt.Printf("Output: %s\n", d.config.Output)
-	fmt.Printf("Threads: %d\n", d.config.Threads)
-	fmt.Printf("Max retry: %d\n", d.config.MaxRetry)
-	fmt.Printf("Validation: %v\n", d.config.ValidateFiles)
+	d.started = time.Now()
+	d.sourceURL = d.config.URL
+	outputTemplate := d.config.Output
+	d.println("Starting M3U8 downloader...")
+	d.printf("URL: %s\n", d.config.URL)
+	d.printf("Output: %s\n", d.config.Output)
+	if d.config.Adaptive {
+		d.printf("Threads: adaptive, %d-%d\n", d.config.MinThreads, d.config.MaxThreads)
+	} else {
+		d.printf("Threads: %d\n", d.config.Threads)
+	}
+	d.printf("Max retry: %d\n", d.config.MaxRetry)
+	d.printf("Validation: %v\n", d.config.ValidateFiles)
+	if d.config.RateLimit > 0 || d.config.HostConnections > 0 {
+		d.printf("Limits: %s\n", describeLimits(d.config.RateLimit, d.config.HostConnections))
+	}
+	if proxyURL, err := utils.ParseProxyURL(d.config.Proxy); err == nil && proxyURL != nil {
+		d.printf("Proxy: %s\n", proxyURL.Redacted())
+	}
+	if d.config.Insecure {
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is DISABLED (-insecure).")
+		fmt.Fprintln(os.Stderr, "WARNING: Connections can be intercepted; use this only in a lab.")
+	}
 
-	// Parse the base URL
-	baseURL, err := utils.GetBaseURL(d.config.URL)
+	// Both limits exist even when unset so they can be turned on while running
+	d.rate = utils.NewRateLimiter(d.config.RateLimit)
+	d.hosts = newHostLimiter(d.config.HostConnections)
+
+	client, err := d.newClient()
 	if err != nil {
-		return fmt.Errorf("error parsing base URL: %w", err)
+		return fmt.Errorf("error configuring HTTP client: %w", err)
+	}
+	d.client = client
+	d.retry = utils.RetryPolicy{
+		MaxRetries: d.config.MaxRetry,
+		BaseDelay:  d.config.RetryDelay,
+		MaxDelay:   d.config.RetryMaxDelay,
 	}
 
 	// Get M3U8 content
-	playlistContent, err := utils.FetchURL(d.config.URL, d.config.Timeout)
+	playlistContent, err := d.fetchPlaylist(d.config.URL)
 	if err != nil {
 		return fmt.Errorf("error fetching M3U8 playlist: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if d.config.ControlAddr != "" {
+		if err := d.startControl(ctx, d.config.ControlAddr); err != nil {
+			return fmt.Errorf("error starting control API: %w", err)
+		}
+	}
+	d.watchSignals(ctx)
+
 	// Check if this is a master playlist (contains variants)
+	var masterURL string
+	pathways := []*pathway{{id: hostOf(d.config.URL), mediaURL: d.config.URL, host: hostOf(d.config.URL)}}
+	var steering *ContentSteering
 	if IsMasterPlaylist(playlistContent) {
-		fmt.Println("Detected master playlist, selecting a stream...")
-		variantURL, err := SelectVariantStream(playlistContent, baseURL)
+		d.println("Detected master playlist, selecting a stream...")
+		masterURL = d.config.URL
+		baseURL, err := utils.GetBaseURL(masterURL)
+		if err != nil {
+			return fmt.Errorf("error parsing base URL: %w", err)
+		}
+
+		var group []Variant
+		group, steering, err = SelectVariantGroup(playlistContent, baseURL)
 		if err != nil {
 			return fmt.Errorf("error selecting variant stream: %w", err)
 		}
+		d.printf("Selected stream with bandwidth: %d\n", group[0].Bandwidth)
+		d.variant = &group[0]
+
+		pathways = newPathways(group)
+		if len(pathways) > 1 {
+			ids := make([]string, len(pathways))
+			for i, p := range pathways {
+				ids[i] = p.id
+			}
+			d.printf("Found %d redundant pathways: %s\n", len(pathways), strings.Join(ids, ", "))
+		}
+	}
+
+	d.source = newPlaylistSource(masterURL, pathways)
 
-		// Update the URL and base URL
-		d.config.URL = variantURL
-		baseURL, err = utils.GetBaseURL(variantURL)
+	// Content steering decides which pathway to start with
+	if steering != nil && steering.ServerURI != "" {
+		if steering.PathwayID != "" {
+			d.source.priority = []string{steering.PathwayID}
+		}
+		manifest, err := d.loadSteering(steering.ServerURI, steering.PathwayID)
 		if err != nil {
-			return fmt.Errorf("error parsing variant base URL: %w", err)
+			d.printf("Error loading steering manifest, using playlist order: %v\n", err)
+		} else {
+			d.applySteering(manifest)
+			d.printf("Pathway priority: %s\n", strings.Join(manifest.PathwayPriority, ", "))
+			go d.followSteering(ctx, steering.ServerURI, manifest)
 		}
+	}
+
+	// Fetch the media playlist from the preferred pathway
+	primary := d.source.pathways[0]
+	if masterURL != "" {
+		d.source.mu.Lock()
+		primary = d.orderedPathways()[0]
+		d.source.mu.Unlock()
+
+		// Update the URL
+		d.config.URL = primary.mediaURL
 
 		// Fetch the selected playlist
-		playlistContent, err = utils.FetchURL(variantURL, d.config.Timeout)
+		playlistContent, err = d.fetchPlaylist(primary.mediaURL)
 		if err != nil {
 			return fmt.Errorf("error fetching variant playlist: %w", err)
 		}
 	}
 
+	// Parse the base URL
+	baseURL, err := utils.GetBaseURL(d.config.URL)
+	if err != nil {
+		return fmt.Errorf("error parsing base URL: %w", err)
+	}
+
 	// Parse segments from playlist
-	segments, err := ParseSegments(playlistContent, baseURL)
+	segments, err := ParseMediaPlaylist(playlistContent, baseURL)
 	if err != nil {
 		return fmt.Errorf("error parsing segments: %w", err)
 	}
@@ -84,303 +209,502 @@ func (d *Downloader) Download() error {
 	if len(segments) == 0 {
 		return fmt.Errorf("no segments found in playlist")
 	}
+	for _, seg := range segments {
+		if seg.InvalidDateTime != "" {
+			d.printf("Ignoring invalid EXT-X-PROGRAM-DATE-TIME %q of segment %d\n", seg.InvalidDateTime, seg.Sequence)
+		}
+	}
 
-	fmt.Printf("Found %d segments to download\n", len(segments))
+	d.source.mu.Lock()
+	primary.setSegments(segments)
+	d.source.mu.Unlock()
+	parsed := segments
+
+	if d.selecting() {
+		segments, err = d.selectSegments(segments)
+		if err != nil {
+			return fmt.Errorf("error selecting segments: %w", err)
+		}
+	}
 
-	// Download segments
-	segmentFiles, err := d.downloadSegments(segments)
+	segments, err = d.applyAdPolicy(segments)
 	if err != nil {
-		return fmt.Errorf("error downloading segments: %w", err)
+		return fmt.Errorf("error removing ad breaks: %w", err)
 	}
 
-	// Merge segments into a single file
-	if err := d.mergeSegments(segmentFiles); err != nil {
-		return fmt.Errorf("error merging segments: %w", err)
+	if d.clipping() {
+		segments, d.clip, err = d.clipSegments(segments)
+		if err != nil {
+			return fmt.Errorf("error selecting time range: %w", err)
+		}
 	}
 
-	// Clean up temporary files if successful
-	fmt.Println("Cleaning up temporary files...")
-	for _, file := range segmentFiles {
-		os.Remove(file)
+	if isOutputTemplate(d.config.Output) {
+		d.config.Output, err = d.resolveOutput(segments)
+		if err != nil {
+			return err
+		}
+		d.printf("Output file: %s\n", d.config.Output)
+	}
+	if d.outputTaken(segments) {
+		d.printf("Output %s already exists, skipping download\n", d.firstOutputFile(d.config.Output, segments))
+		return nil
+	}
+
+	d.printf("Found %d segments to download\n", len(segments))
+
+	d.job, err = d.openJob(outputTemplate)
+	if err != nil {
+		return err
+	}
+
+	if d.config.SpaceCheck {
+		if err := d.checkDiskSpace(ctx, segments); err != nil {
+			d.abandonJob()
+			return err
+		}
+	}
+	d.space = d.newSpaceGuard()
+
+	// Download segments, merging them into the output as they complete
+	if err := d.downloadSegments(ctx, segments); err != nil {
+		d.abandonJob()
+		return fmt.Errorf("error downloading segments: %w", err)
+	}
+
+	if d.chaptersEnabled() {
+		if err := d.writeChapters(parsed, segments); err != nil {
+			d.abandonJob()
+			return fmt.Errorf("error writing chapters: %w", err)
+		}
 	}
-	os.RemoveAll(d.config.OutputDir)
 
-	fmt.Printf("Process completed successfully! File saved as: %s\n", d.config.Output)
+	// Clean up the files this download created, never anything else in -dir
+	if d.config.KeepSegments {
+		d.printf("Segments kept in %s\n", d.job.dir)
+	} else {
+		d.println("Cleaning up temporary files...")
+	}
+	d.job.finish(d.config.KeepSegments)
+
+	if d.config.Output == "-" {
+		d.println("Process completed successfully! Output written to stdout")
+	} else if parts := d.merger.done; len(parts) > 1 {
+		d.printf("Process completed successfully! Saved %d parts: %s\n", len(parts), strings.Join(parts, ", "))
+	} else {
+		d.printf("Process completed successfully! File saved as: %s\n", d.merger.done[0])
+	}
 	return nil
 }
 
-// downloadFile downloads a single file with proper error handling and retries
-func (d *Downloader) downloadFile(url, fileName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+// fetchPlaylist retrieves a playlist using the shared retry policy
+func (d *Downloader) fetchPlaylist(url string) (string, error) {
+	var content string
+	err := d.retry.Do(context.Background(), func() error {
+		var err error
+		content, err = d.client.Fetch(url, d.config.Timeout)
+		return err
+	}, func(attempt int, err error, wait time.Duration) {
+		d.printf("Playlist request failed: %v (retry %d/%d in %v)\n",
+			err, attempt, d.config.MaxRetry, wait.Round(time.Millisecond))
+	})
+	return content, err
+}
+
+// reorderWindow returns how many segments may be downloaded ahead of the write position
+func (d *Downloader) reorderWindow() int {
+	if d.config.ReorderWindow > 0 {
+		return d.config.ReorderWindow
+	}
+	return 4 * d.maxThreads()
+}
+
+// downloadSegment downloads a segment into memory when the reorder buffer has room
+// for it, otherwise to fileName, going through a temporary file. A transfer that
+// broke off is continued from its temporary file when the server accepts ranges.
+// The caller holds a connection slot for the host of url.
+func (d *Downloader) downloadSegment(ctx context.Context, url, fileName string) (*segmentData, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	timeout := newRequestTimeout(d.config.Timeout, cancel)
+	defer timeout.stop()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := d.client.NewRequest(ctx, url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req.Header.Set("User-Agent", utils.UserAgent)
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Accept", "*/*")
-
-	transport := &http.Transport{
-		DisableCompression:  true,
-		MaxIdleConnsPerHost: 5,
-		IdleConnTimeout:     30 * time.Second,
+	tempFileName := fileName + ".tmp"
+	partial := d.takePartial(fileName, url)
+	if partial != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", partial.size))
+		if partial.validator != "" {
+			req.Header.Set("If-Range", partial.validator)
+		}
 	}
-	client := &http.Client{Transport: transport}
 
-	resp, err := client.Do(req)
+	resp, err := d.client.Do(req)
 	if err != nil {
-		return err
+		if partial != nil {
+			d.keepPartial(fileName, partial)
+		}
+		return nil, timeout.err(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP status code: %d", resp.StatusCode)
+	var offset int64
+	switch {
+	case partial != nil && resp.StatusCode == http.StatusPartialContent && resumeOffset(resp) == partial.size:
+		offset = partial.size
+	case resp.StatusCode == http.StatusOK:
+		// Sent whole, the server ignored the range or the segment changed
+		if partial != nil {
+			partial.remove()
+		}
+	default:
+		if partial != nil {
+			partial.remove()
+			if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+				return nil, fmt.Errorf("could not resume at byte %d (HTTP status code: %d), starting over", partial.size, resp.StatusCode)
+			}
+		}
+		return nil, utils.NewHTTPError(resp)
 	}
 
-	tempFileName := fileName + ".tmp"
-	out, err := os.Create(tempFileName)
-	if err != nil {
-		return err
+	watch := d.watchTransfer(cancel)
+	defer watch.stop()
+	if watch != nil {
+		// The stall checks take over, however long a large body takes
+		timeout.stop()
 	}
+	body := d.limitBody(ctx, resp.Body, timeout, watch)
 
-	bufferedWriter := bufio.NewWriter(out)
-	_, err = io.Copy(bufferedWriter, resp.Body)
+	// Further chunks are separate requests, bound by their own timeouts
+	if offset == 0 && d.chunkable(resp) {
+		return d.downloadChunked(parent, url, fileName, resp, body, func(err error) error {
+			return diskFull(watch.err(timeout.err(err)))
+		})
+	}
+
+	if size := resp.ContentLength; offset == 0 && size > 0 && !d.config.KeepSegments && d.merger.reserve(size) {
+		data := make([]byte, size)
+		n, err := io.ReadFull(body, data)
+		if err != nil {
+			d.merger.release(size)
+			err = watch.err(timeout.err(err))
+			if n > 0 && resumable(resp, err) && os.WriteFile(tempFileName, data[:n], 0644) == nil {
+				d.keepPartial(fileName, newPartial(url, tempFileName, int64(n), resp))
+			}
+			return nil, err
+		}
+		return &segmentData{data: data, size: size}, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(tempFileName, flags, 0644)
 	if err != nil {
-		out.Close()
-		os.Remove(tempFileName)
-		return err
+		return nil, err
 	}
 
-	if err = bufferedWriter.Flush(); err != nil {
+	bufferedWriter := bufio.NewWriter(out)
+	size, err := io.Copy(bufferedWriter, body)
+	if flushErr := bufferedWriter.Flush(); err == nil {
+		err = flushErr
+	}
+	err = watch.err(timeout.err(err))
+	if err != nil {
 		out.Close()
-		os.Remove(tempFileName)
-		return err
+		if offset+size > 0 && resumable(resp, err) {
+			d.keepPartial(fileName, newPartial(url, tempFileName, offset+size, resp))
+		} else {
+			os.Remove(tempFileName)
+		}
+		return nil, diskFull(err)
 	}
 
 	out.Close()
 
 	if err = os.Rename(tempFileName, fileName); err != nil {
 		os.Remove(tempFileName)
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &segmentData{file: fileName, size: offset + size}, nil
+}
+
+// limitBody applies the rate limit and stall checks to a response body. Time held
+// back by the rate limit counts neither against the timeout nor as a stall.
+func (d *Downloader) limitBody(ctx context.Context, body io.Reader, timeout *requestTimeout, watch *transferWatch) io.Reader {
+	return d.rate.Reader(ctx, watch.Reader(body), func(hold time.Duration) {
+		timeout.extend(hold)
+		watch.hold(hold)
+	})
 }
 
-// downloadSegments downloads all segments concurrently
-func (d *Downloader) downloadSegments(segments []string) ([]string, error) {
+// downloadSegments downloads all segments concurrently, retrying each one on its own,
+// and streams them into the output file in playlist order
+func (d *Downloader) downloadSegments(ctx context.Context, segments []Segment) error {
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, d.config.Threads)
+	workers := newWorkerPool(d.config.Threads)
+	if d.config.Adaptive {
+		workers.resize(min(max(d.config.Threads, d.config.MinThreads), d.config.MaxThreads))
+		d.adaptive = newConcurrencyController(workers, d.config.MinThreads, d.config.MaxThreads, d.printf)
+	}
+	d.workers = workers
+	d.hedge = d.newHedger()
 	var mu sync.Mutex
-	var errorOccurred bool
+	var firstErr error
 
-	segmentFiles := make([]string, len(segments))
-	ctx, cancel := context.WithCancel(context.Background())
+	sequences := make([]int, len(segments))
+	for i, seg := range segments {
+		sequences[i] = seg.Sequence
+	}
+	merger, err := newMergeWriter(d.config.Output, sequences, d.reorderWindow(), d.config.ReorderMemory)
+	if err != nil {
+		return err
+	}
+	merger.exists = d.config.OutputExists
+	if merger.tempName != "" {
+		d.job.recordTemp(merger.tempName)
+	}
+	merger.onTemp = d.job.recordTemp
+	if d.config.Output != "-" {
+		outputDir := filepath.Dir(d.config.Output)
+		merger.checkSpace = func(ctx context.Context) error { return d.space.wait(ctx, outputDir) }
+	}
+	if d.clip != nil {
+		merger.transform = d.clip.transform
+	}
+	d.merger = merger
+
+	if d.splitting() {
+		if err := merger.splitAt(d.partStarts(segments), d.config.SplitSize, d.partNamer(segments)); err != nil {
+			merger.abort()
+			return err
+		}
+	}
+
+	if d.config.FixTimestamps {
+		if marks, n := discontinuities(segments); n > 0 {
+			merger.restamp = mpegts.NewRestamper(merger.writer)
+			merger.discontinuity = marks
+			d.printf("Rewriting timestamps across %d discontinuities\n", n)
+		}
+	}
+
+	if d.config.ValidateFiles {
+		d.timing = newTimingReport(segments)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	var failedSegments []int
 	var progress atomic.Uint32
 	total := uint32(len(segments))
 	progress.Store(0)
 
-	fmt.Printf("Starting download of %d segments with %d concurrent threads\n",
-		len(segments), d.config.Threads)
+	if d.adaptive != nil {
+		d.printf("Starting download of %d segments with %d concurrent threads (adaptive, %d-%d)\n",
+			len(segments), workers.getSize(), d.config.MinThreads, d.config.MaxThreads)
+	} else {
+		d.printf("Starting download of %d segments with %d concurrent threads\n",
+			len(segments), d.config.Threads)
+	}
+	if d.config.Output == "-" {
+		d.println("Streaming merged segments to stdout")
+	} else {
+		d.println("Merging segments into", d.config.Output)
+	}
+
+	// The writer appends segments as soon as the contiguous prefix is complete
+	writeErr := make(chan error, 1)
+	go func() {
+		err := merger.run(ctx)
+		if err != nil {
+			cancel()
+		}
+		writeErr <- err
+	}()
 
-	// First download attempt
-	for i, segmentURL := range segments {
+	for i, seg := range segments {
 		wg.Add(1)
-		go func(i int, segmentURL string) {
+		go func(i int, seg Segment) {
 			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
 
-			select {
-			case <-ctx.Done():
+			// Stay within the reorder window so buffered segments stay bounded
+			if err := merger.waitTurn(ctx, i); err != nil {
 				return
-			default:
 			}
 
-			fileName := filepath.Join(d.config.OutputDir, fmt.Sprintf("segment_%05d.ts", i))
-			err := d.downloadFile(segmentURL, fileName)
+			fileName := d.job.path(seg.Sequence)
 
-			mu.Lock()
-			if err == nil {
-				segmentFiles[i] = fileName
-				progress.Add(1)
-				fmt.Printf("\rProgress: %.2f%%", (float64(progress.Load())/float64(total))*100)
-			} else {
-				failedSegments = append(failedSegments, i)
-				fmt.Printf("\nInitial download failed for segment %d: %v (will retry)\n", i, err)
+			// Segments saved by an interrupted run are used when they still pass the checks
+			data := d.job.saved(seg.Sequence)
+			if data != nil && d.checkSegment(i, data) != nil {
+				data = nil
 			}
-			mu.Unlock()
-		}(i, segmentURL)
-	}
 
-	wg.Wait()
-
-	// Retry failed segments
-	for retryCount := 1; retryCount <= d.config.MaxRetry; retryCount++ {
-		if len(failedSegments) == 0 {
-			break
-		}
-
-		fmt.Printf("\nRetrying %d failed segments (attempt %d/%d)...\n",
-			len(failedSegments), retryCount, d.config.MaxRetry)
-
-		backoffTime := time.Duration(retryCount) * time.Second
-		time.Sleep(backoffTime)
-
-		var stillFailedSegments []int
-		var retryWg sync.WaitGroup
-
-		for _, i := range failedSegments {
-			retryWg.Add(1)
-			go func(i int) {
-				defer retryWg.Done()
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
+			// A worker is only held while a request is in flight, not during backoff
+			var err error
+			failures := make(map[string]int)
+			if data == nil {
+				err = d.retry.Do(ctx, func() error {
+					// Retrying would not help while the disk is full
+					if err := d.space.wait(ctx, d.job.dir); err != nil {
+						return utils.Permanent(err)
+					}
 
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
+					if err := workers.acquire(ctx); err != nil {
+						return err
+					}
+					defer workers.release()
+
+					// Fail over to redundant pathways when the preferred one keeps failing
+					var err error
+					candidates := d.candidatePathways(failures)
+					for n, p := range candidates {
+						// A hedge goes to the next pathway, or to the same one when there is no other
+						backup := p
+						if n+1 < len(candidates) {
+							backup = candidates[n+1]
+						}
+						data, err = d.downloadHedged(ctx, p, backup, seg, fileName)
+						if err == nil {
+							return d.checkSegment(i, data)
+						}
+						if ctx.Err() != nil {
+							return err
+						}
+
+						if utils.IsRetryable(err) {
+							failures[p.id]++
+							if failures[p.id] < failoverAfter {
+								return err
+							}
+						} else {
+							failures[p.id] = failoverAfter
+						}
+
+						if n+1 < len(candidates) {
+							mu.Lock()
+							d.printf("\nSegment %d failed on pathway %s: %v (trying %s)\n",
+								seg.Sequence, p.id, err, candidates[n+1].id)
+							mu.Unlock()
+						}
+					}
+					return err
+				}, func(attempt int, err error, wait time.Duration) {
+					mu.Lock()
+					d.printf("\nDownload failed for segment %d: %v (retry %d/%d in %v)\n",
+						seg.Sequence, err, attempt, d.config.MaxRetry, wait.Round(time.Millisecond))
+					mu.Unlock()
+				})
+			}
+			if err != nil {
+				d.dropPartial(fileName)
+			}
+			if err == nil && data.file != "" {
+				d.job.recordSegment(seg.Sequence, data.size)
+				data.keep = d.config.KeepSegments
+			}
 
-				fileName := filepath.Join(d.config.OutputDir, fmt.Sprintf("segment_%05d.ts", i))
-				err := d.downloadFile(segments[i], fileName)
-
-				mu.Lock()
-				if err == nil {
-					segmentFiles[i] = fileName
-					fmt.Printf("Successfully downloaded segment %d on retry %d\n", i+1, retryCount)
-				} else {
-					stillFailedSegments = append(stillFailedSegments, i)
-					if retryCount == d.config.MaxRetry && !errorOccurred {
-						errorOccurred = true
-						fmt.Printf("Failed to download segment %d after %d retries: %v\n",
-							i+1, d.config.MaxRetry, err)
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				// Stop the remaining downloads, the result would be incomplete anyway
+				if firstErr == nil && ctx.Err() == nil {
+					if utils.IsRetryable(err) {
+						firstErr = fmt.Errorf("segment %d failed to download after %d retries: %w", seg.Sequence, d.config.MaxRetry, err)
+					} else {
+						firstErr = fmt.Errorf("segment %d failed permanently: %w", seg.Sequence, err)
 					}
+					cancel()
 				}
-				mu.Unlock()
-			}(i)
-		}
+				return
+			}
 
-		retryWg.Wait()
-		failedSegments = stillFailedSegments
+			merger.deliver(i, data)
+			progress.Add(1)
+			d.printf("\rProgress: %.2f%%", (float64(progress.Load())/float64(total))*100)
+		}(i, seg)
 	}
 
-	// Verify all segments
-	for i, file := range segmentFiles {
-		if file == "" {
-			return nil, fmt.Errorf("segment %d failed to download after all retries", i+1)
-		}
-
-		fileInfo, err := os.Stat(file)
-		if err != nil {
-			return nil, fmt.Errorf("cannot access segment file %d: %w", i+1, err)
-		}
-
-		if fileInfo.Size() == 0 {
-			return nil, fmt.Errorf("segment file %d is empty (0 bytes)", i+1)
-		}
+	wg.Wait()
+	err = <-writeErr
 
-		if d.config.ValidateFiles {
-			if err := validator.ValidateTS(file); err != nil {
-				return nil, fmt.Errorf("segment file %d failed integrity check: %w", i+1, err)
-			}
-		}
+	if firstErr != nil {
+		err = firstErr
 	}
-
-	fmt.Println("\nAll segments downloaded successfully!")
-	return segmentFiles, nil
-}
-
-// mergeSegments combines all downloaded segments into a single output file
-func (d *Downloader) mergeSegments(segmentFiles []string) error {
-	tempOutputFile := d.config.Output + ".tmp"
-	out, err := os.Create(tempOutputFile)
 	if err != nil {
+		d.println()
+		d.printHostHealth()
+		d.printConcurrency()
+		d.printHedging()
+		merger.abort()
 		return err
 	}
 
-	bufferedWriter := bufio.NewWriterSize(out, downloadBufferSize)
-	fmt.Println("Merging segments into", d.config.Output)
-
-	buffer := make([]byte, mergeBufferSize)
-
-	for i, file := range segmentFiles {
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			out.Close()
-			os.Remove(tempOutputFile)
-			return fmt.Errorf("segment file missing: %s", file)
-		}
-
-		in, err := os.Open(file)
-		if err != nil {
-			out.Close()
-			os.Remove(tempOutputFile)
+	if err := merger.finish(); err != nil {
+		if errors.Is(err, ErrBrokenPipe) {
 			return err
 		}
+		return fmt.Errorf("error finishing output: %w", err)
+	}
 
-		reader := bufio.NewReader(in)
-
-		for {
-			n, err := reader.Read(buffer)
-			if err != nil && err != io.EOF {
-				in.Close()
-				out.Close()
-				os.Remove(tempOutputFile)
-				return err
-			}
-
-			if n == 0 {
-				break
-			}
-
-			if _, err := bufferedWriter.Write(buffer[:n]); err != nil {
-				in.Close()
-				out.Close()
-				os.Remove(tempOutputFile)
-				return err
-			}
-		}
-
-		in.Close()
+	d.println("\nAll segments downloaded successfully!")
+	d.printHostHealth()
+	d.printConcurrency()
+	d.printHedging()
+	d.printTimingReport()
+	d.println("Merge completed successfully!")
+	return nil
+}
 
-		if err := bufferedWriter.Flush(); err != nil {
-			out.Close()
-			os.Remove(tempOutputFile)
-			return err
-		}
+// checkSegment verifies downloaded segment i before it is handed to the writer
+func (d *Downloader) checkSegment(i int, data *segmentData) error {
+	if data.size == 0 {
+		d.releaseSegment(data)
+		return utils.Permanent(fmt.Errorf("segment is empty (0 bytes)"))
+	}
 
-		if i%10 == 0 || i == len(segmentFiles)-1 {
-			fmt.Printf("Merged %d/%d segments\r", i+1, len(segmentFiles))
-		}
+	if !d.config.ValidateFiles {
+		return nil
 	}
 
-	if err := bufferedWriter.Flush(); err != nil {
-		out.Close()
-		os.Remove(tempOutputFile)
+	r, err := data.open()
+	if err != nil {
 		return err
 	}
-
-	out.Close()
-
-	if _, err := os.Stat(d.config.Output); err == nil {
-		if err := os.Remove(d.config.Output); err != nil {
-			os.Remove(tempOutputFile)
-			return fmt.Errorf("failed to remove existing output file: %w", err)
-		}
+	info, err := validator.Validate(r)
+	r.Close()
+
+	// A container without a validator is accepted as it is, downloading it again would not help
+	if errors.Is(err, validator.ErrUnknownFormat) {
+		d.unknown.Do(func() {
+			d.println("\nUnrecognized segment format, segments in it are not validated")
+		})
+		return nil
 	}
 
-	if err := os.Rename(tempOutputFile, d.config.Output); err != nil {
-		os.Remove(tempOutputFile)
-		return fmt.Errorf("failed to rename temporary file: %w", err)
+	// A corrupt or truncated segment is usually a transfer problem, so it is downloaded again
+	if err != nil {
+		d.releaseSegment(data)
+		return fmt.Errorf("failed integrity check: %w", err)
 	}
 
-	fmt.Println("\nMerge completed successfully!")
+	d.timing.record(i, info)
 	return nil
 }
+
+// releaseSegment drops a segment that will not be handed to the writer
+func (d *Downloader) releaseSegment(data *segmentData) {
+	if data.data != nil {
+		d.merger.release(data.size)
+	}
+	data.remove()
+}
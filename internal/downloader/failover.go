@@ -0,0 +1,279 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+const (
+	failoverAfter  = 2                // Failed attempts of a segment before trying the next pathway
+	unhealthyAfter = 3                // Consecutive failures before a host is demoted
+	unhealthyFor   = 30 * time.Second // How long a demoted host stays at the back of the queue
+)
+
+// errCalledOff is returned for a request its ready callback called off
+var errCalledOff = errors.New("request called off")
+
+// pathway is one copy of the selected variant, on its own host or CDN
+type pathway struct {
+	id       string
+	mediaURL string
+	host     string         // Host serving the segments, used for health tracking
+	urls     map[int]string // URL per media sequence number, nil until the playlist was loaded
+	loads    int            // Times the playlist was loaded, to tell refreshes apart
+}
+
+// hostStats tracks the request outcomes for a single host
+type hostStats struct {
+	requests    int
+	failures    int
+	consecutive int
+	lastFailure time.Time
+}
+
+// newPathways creates a pathway per redundant variant, naming unsteered ones after their host
+func newPathways(variants []Variant) []*pathway {
+	pathways := make([]*pathway, 0, len(variants))
+	seen := make(map[string]int)
+
+	for _, v := range variants {
+		id := v.PathwayID
+		if id == "" {
+			id = hostOf(v.URL)
+		}
+		seen[id]++
+		if seen[id] > 1 {
+			id = fmt.Sprintf("%s#%d", id, seen[id])
+		}
+		pathways = append(pathways, &pathway{id: id, mediaURL: v.URL, host: hostOf(v.URL)})
+	}
+
+	return pathways
+}
+
+// setSegments records the segment URLs of the pathway's media playlist.
+// The caller must hold d.source.mu.
+func (p *pathway) setSegments(segments []Segment) {
+	if p.urls == nil {
+		p.urls = make(map[int]string, len(segments))
+	}
+	for _, s := range segments {
+		p.urls[s.Sequence] = s.URL
+	}
+	if len(segments) > 0 {
+		p.host = hostOf(segments[0].URL)
+	}
+	p.loads++
+}
+
+// hostOf returns the host name of a URL, or the URL itself when it cannot be parsed
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Hostname()
+}
+
+// recordResult updates the health of the host that served url
+func (d *Downloader) recordResult(rawURL string, err error) {
+	host := hostOf(rawURL)
+
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+
+	stats, ok := d.source.health[host]
+	if !ok {
+		stats = &hostStats{}
+		d.source.health[host] = stats
+	}
+
+	stats.requests++
+	if err == nil {
+		stats.consecutive = 0
+		return
+	}
+	stats.failures++
+	stats.consecutive++
+	stats.lastFailure = time.Now()
+}
+
+// unhealthy reports whether a host keeps failing and should only be used as a last resort.
+// The caller must hold d.source.mu.
+func (d *Downloader) unhealthy(host string) bool {
+	stats, ok := d.source.health[host]
+	if !ok {
+		return false
+	}
+	return stats.consecutive >= unhealthyAfter && time.Since(stats.lastFailure) < unhealthyFor
+}
+
+// orderedPathways returns the pathways by steering priority, healthy hosts first.
+// The caller must hold d.source.mu.
+func (d *Downloader) orderedPathways() []*pathway {
+	rank := make(map[string]int, len(d.source.priority))
+	for i, id := range d.source.priority {
+		rank[id] = i
+	}
+
+	ordered := append([]*pathway(nil), d.source.pathways...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		hi, hj := d.unhealthy(ordered[i].host), d.unhealthy(ordered[j].host)
+		if hi != hj {
+			return !hi
+		}
+		ri, ok := rank[ordered[i].id]
+		if !ok {
+			ri = len(rank)
+		}
+		rj, ok := rank[ordered[j].id]
+		if !ok {
+			rj = len(rank)
+		}
+		return ri < rj
+	})
+
+	return ordered
+}
+
+// candidatePathways returns the pathways still worth trying for a segment, best first
+func (d *Downloader) candidatePathways(failures map[string]int) []*pathway {
+	d.source.mu.Lock()
+	ordered := d.orderedPathways()
+	d.source.mu.Unlock()
+
+	var candidates []*pathway
+	for _, p := range ordered {
+		if failures[p.id] < failoverAfter {
+			candidates = append(candidates, p)
+		}
+	}
+
+	// Every pathway failed this segment, start over with the best one
+	if len(candidates) == 0 {
+		for id := range failures {
+			delete(failures, id)
+		}
+		candidates = ordered
+	}
+
+	return candidates
+}
+
+// pathwayURL returns the URL of a segment on a pathway, loading its playlist on first use
+func (d *Downloader) pathwayURL(p *pathway, seg Segment) (string, error) {
+	d.source.mu.Lock()
+	urls := p.urls
+	d.source.mu.Unlock()
+
+	if urls == nil {
+		d.source.loadMu.Lock()
+		d.source.mu.Lock()
+		urls = p.urls
+		d.source.mu.Unlock()
+
+		// Nobody loaded it while we waited
+		if urls == nil {
+			d.printf("\nLoading backup playlist for pathway %s...\n", p.id)
+			segments, err := d.fetchMediaSegments(p)
+			if err != nil {
+				d.source.loadMu.Unlock()
+				return "", fmt.Errorf("error loading playlist for pathway %s: %w", p.id, err)
+			}
+			d.source.mu.Lock()
+			p.setSegments(segments)
+			d.source.mu.Unlock()
+		}
+		d.source.loadMu.Unlock()
+	}
+
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+
+	u, ok := p.urls[seg.Sequence]
+	if !ok {
+		return "", utils.Permanent(fmt.Errorf("segment %d is not listed on pathway %s", seg.Sequence, p.id))
+	}
+	return u, nil
+}
+
+// downloadFromPathway downloads a segment from one pathway, refreshing its URL once
+// if it expired. ready is passed on to fetchSegment.
+func (d *Downloader) downloadFromPathway(ctx context.Context, p *pathway, seg Segment, fileName string, ready func() bool) (*segmentData, error) {
+	url, err := d.pathwayURL(p, seg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.fetchSegment(ctx, url, fileName, ready)
+	if !isExpired(err) {
+		return data, err
+	}
+
+	// Signed URLs may have expired, try again with a freshly signed one
+	freshURL, refreshErr := d.refreshSegmentURL(p, seg, url)
+	if refreshErr != nil {
+		d.printf("\n%v\n", refreshErr)
+		return nil, err
+	}
+
+	return d.fetchSegment(ctx, freshURL, fileName, ready)
+}
+
+// fetchSegment downloads a segment from url once a connection to its host is free,
+// and records the outcome for host health, adaptive concurrency and hedging. When
+// ready is not nil it is called once the connection is held, and the request is
+// only sent if it returns true.
+func (d *Downloader) fetchSegment(ctx context.Context, url, fileName string, ready func() bool) (*segmentData, error) {
+	// Waiting for the host counts neither against the timeout nor as latency
+	host := hostOf(url)
+	if err := d.hosts.acquire(ctx, host); err != nil {
+		return nil, err
+	}
+	defer d.hosts.release(host)
+
+	if ready != nil && !ready() {
+		return nil, errCalledOff
+	}
+
+	started := time.Now()
+	data, err := d.downloadSegment(ctx, url, fileName)
+	if ctx.Err() == nil {
+		elapsed := time.Since(started)
+		d.recordResult(url, err)
+		d.adaptive.record(data, elapsed, err)
+		if err == nil {
+			d.hedge.record(elapsed)
+		}
+	}
+	return data, err
+}
+
+// printHostHealth reports hosts that had failures during the download
+func (d *Downloader) printHostHealth() {
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+
+	hosts := make([]string, 0, len(d.source.health))
+	for host, stats := range d.source.health {
+		if stats.failures > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	sort.Strings(hosts)
+	d.println("Host health:")
+	for _, host := range hosts {
+		stats := d.source.health[host]
+		d.printf("  %s: %d/%d requests failed\n", host, stats.failures, stats.requests)
+	}
+}
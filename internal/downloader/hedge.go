@@ -0,0 +1,208 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeMinSamples = 10  // Segment latencies observed before any request is hedged
+	hedgeSamples    = 200 // Most recent latencies the percentile is taken from
+)
+
+// hedger decides when a slow segment request gets a second, hedged request. A
+// request is hedged once it has run longer than the given percentile of recent
+// segment latencies, for at most budget percent of the requests.
+type hedger struct {
+	percentile float64
+	budget     float64
+
+	mu        sync.Mutex
+	latencies []time.Duration // Ring of the most recent latencies
+	next      int
+	requests  int // Segment requests started
+	hedges    int // Of which were hedged
+	won       int // Hedges that finished first
+}
+
+// newHedger creates the hedger of a download, nil when hedging is off
+func (d *Downloader) newHedger() *hedger {
+	if d.config.HedgePercentile <= 0 {
+		return nil
+	}
+	return &hedger{percentile: d.config.HedgePercentile, budget: d.config.HedgeBudget}
+}
+
+// record adds the latency of a successful segment request
+func (h *hedger) record(latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgeSamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// delay returns how long a new request runs before it is hedged, false while
+// too few latencies are known
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests++
+	if len(h.latencies) < hedgeMinSamples {
+		return 0, false
+	}
+	sorted := slices.Clone(h.latencies)
+	slices.Sort(sorted)
+	i := min(int(float64(len(sorted))*h.percentile/100), len(sorted)-1)
+	return sorted[i], true
+}
+
+// take reserves a hedge within the budget
+func (h *hedger) take() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if float64(h.hedges+1) > float64(h.requests)*h.budget/100 {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+// hedgeWon counts a hedge that finished before the request it backed up
+func (h *hedger) hedgeWon() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.won++
+}
+
+// summary describes how many requests were hedged
+func (h *hedger) summary() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf("Hedged %d of %d segment requests after the p%g latency (budget %g%%), the hedge finished first %d times",
+		h.hedges, h.requests, h.percentile, h.budget, h.won)
+}
+
+// printHedging reports how many requests were hedged
+func (d *Downloader) printHedging() {
+	if d.hedge != nil {
+		d.println(d.hedge.summary())
+	}
+}
+
+// hedgeResult is the outcome of one of the requests for a hedged segment
+type hedgeResult struct {
+	data  *segmentData
+	err   error
+	hedge bool
+}
+
+// downloadHedged downloads a segment from p. When that takes longer than the
+// hedge delay, counted from when the request holds its host connection, a second
+// request goes to backup, which may be p again; the first to finish is kept and
+// the other cancelled.
+func (d *Downloader) downloadHedged(ctx context.Context, p, backup *pathway, seg Segment, fileName string) (*segmentData, error) {
+	if d.hedge == nil {
+		return d.downloadFromPathway(ctx, p, seg, fileName, nil)
+	}
+	delay, ok := d.hedge.delay()
+	if !ok {
+		return d.downloadFromPathway(ctx, p, seg, fileName, nil)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The hedge delay starts once the request is sent, not while it waits for its host
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+	sent := make(chan struct{})
+	var once sync.Once
+
+	results := make(chan hedgeResult, 2)
+	go func() {
+		data, err := d.downloadFromPathway(ctx, p, seg, fileName, func() bool {
+			once.Do(func() { close(sent) })
+			return true
+		})
+		results <- hedgeResult{data: data, err: err}
+	}()
+
+	// The hedge saves under its own name so the two requests never share a file.
+	// It counts against the budget only once it holds a connection itself.
+	hedgeFile := fileName + ".hedge"
+	defer d.dropPartial(hedgeFile)
+	var taken bool
+	takeHedge := func() bool {
+		if !taken {
+			taken = d.hedge.take()
+		}
+		return taken
+	}
+
+	running := 1
+	var winner, failed *hedgeResult
+	for running > 0 {
+		select {
+		case <-sent:
+			sent = nil
+			timer.Reset(delay)
+
+		case <-timer.C:
+			if winner != nil {
+				continue
+			}
+			running++
+			go func() {
+				data, err := d.downloadFromPathway(ctx, backup, seg, hedgeFile, takeHedge)
+				results <- hedgeResult{data: data, err: err, hedge: true}
+			}()
+
+		case r := <-results:
+			running--
+			switch {
+			case errors.Is(r.err, errCalledOff):
+				// The budget was spent while the hedge waited for its host
+			case r.err == nil && winner == nil:
+				winner = &r
+				cancel()
+			case r.err == nil:
+				// Both finished, the later one is not needed
+				d.releaseSegment(r.data)
+			case failed == nil && winner == nil:
+				// Reported when the other request fails too, or was never sent
+				failed = &r
+			}
+		}
+	}
+
+	if winner == nil {
+		return nil, failed.err
+	}
+	if winner.hedge {
+		d.hedge.hedgeWon()
+		if winner.data.file != "" {
+			if err := os.Rename(hedgeFile, fileName); err != nil {
+				d.releaseSegment(winner.data)
+				return nil, err
+			}
+			winner.data.file = fileName
+		}
+	}
+	return winner.data, nil
+}
@@ -0,0 +1,277 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m3u8-downloader/internal/config"
+)
+
+const (
+	jobPrefix   = "job-"
+	journalName = "journal"
+)
+
+// job is the working directory of one download inside -dir. Its journal records
+// every file the download creates, so only those are ever deleted, and lists the
+// saved segments so a run that was interrupted can pick them up again.
+//
+// The journal is a text file with one entry per line:
+//
+//	url <playlist URL>
+//	output <output name>
+//	pid <process ID> <start time>
+//	segment <media sequence number> <size>
+//	temp <temporary output file>
+//	done
+type job struct {
+	dir string
+
+	mu       sync.Mutex
+	journal  *os.File
+	segments map[int]int64 // Saved segment files by media sequence number
+	temps    []string
+}
+
+// jobState is what the journal of a job directory says about it
+type jobState struct {
+	url      string
+	output   string
+	pid      int
+	done     bool
+	segments map[int]int64
+	temps    []string
+}
+
+// jobDirName names the job directory of a playlist and output, so running the
+// same download again finds the directory of an interrupted run
+func jobDirName(url, output string) string {
+	sum := sha256.Sum256([]byte(url + "\n" + output))
+	return jobPrefix + hex.EncodeToString(sum[:6])
+}
+
+// readJournal parses the journal of a job directory
+func readJournal(dir string) (*jobState, error) {
+	f, err := os.Open(filepath.Join(dir, journalName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	state := &jobState{segments: make(map[int]int64)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "url":
+			state.url = value
+		case "output":
+			state.output = value
+		case "pid":
+			pid, _, _ := strings.Cut(value, " ")
+			state.pid, _ = strconv.Atoi(pid)
+			state.done = false
+		case "segment":
+			seq, size, _ := strings.Cut(value, " ")
+			n, err1 := strconv.Atoi(seq)
+			s, err2 := strconv.ParseInt(size, 10, 64)
+			if err1 == nil && err2 == nil {
+				state.segments[n] = s
+			}
+		case "temp":
+			state.temps = append(state.temps, value)
+		case "done":
+			state.done = true
+		}
+	}
+	return state, scanner.Err()
+}
+
+// stale reports whether the job was left behind by a run that did not finish
+func (s *jobState) stale() bool {
+	return !s.done && !processAlive(s.pid)
+}
+
+// clean deletes the files listed in a journal, the journal and, when nothing
+// else is left in it, the job directory
+func (s *jobState) clean(dir string) {
+	for seq := range s.segments {
+		os.Remove(filepath.Join(dir, segmentFileName(seq)))
+		os.Remove(filepath.Join(dir, segmentFileName(seq)+".tmp"))
+	}
+	for _, name := range s.temps {
+		os.Remove(name)
+	}
+	os.Remove(filepath.Join(dir, journalName))
+	os.Remove(dir)
+}
+
+// segmentFileName names the file a segment is saved in
+func segmentFileName(seq int) string {
+	return fmt.Sprintf("segment_%05d.ts", seq)
+}
+
+// openJob creates or takes over the job directory of a download. Segments saved
+// by an earlier run are kept for reuse with StaleResume and deleted otherwise.
+func (d *Downloader) openJob(output string) (*job, error) {
+	d.reportStaleJobs()
+
+	dir := filepath.Join(d.config.OutputDir, jobDirName(d.sourceURL, output))
+	j := &job{dir: dir, segments: make(map[int]int64)}
+
+	if state, err := readJournal(dir); err == nil {
+		switch {
+		case !state.done && processAlive(state.pid) && state.pid != os.Getpid():
+			return nil, fmt.Errorf("another run (pid %d) is downloading this playlist to %s, job directory %s",
+				state.pid, output, dir)
+		case d.config.StaleJobs == config.StaleClean:
+			d.printf("Cleaning up job directory %s from an earlier run\n", dir)
+			state.clean(dir)
+		default:
+			// Partial outputs cannot be continued, saved segments can
+			for _, name := range state.temps {
+				os.Remove(name)
+			}
+			for seq, size := range state.segments {
+				if info, err := os.Stat(filepath.Join(dir, segmentFileName(seq))); err == nil && info.Size() == size {
+					j.segments[seq] = size
+				}
+			}
+			if len(j.segments) > 0 {
+				d.printf("Resuming job %s: %d segments already downloaded\n", dir, len(j.segments))
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading job journal: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating job directory: %w", err)
+	}
+
+	// Start a fresh journal listing what is still valid
+	journal, err := os.Create(filepath.Join(dir, journalName))
+	if err != nil {
+		return nil, fmt.Errorf("error creating job journal: %w", err)
+	}
+	j.journal = journal
+	j.write(fmt.Sprintf("url %s\noutput %s\npid %d %s\n", d.sourceURL, output, os.Getpid(), d.started.Format(time.RFC3339)))
+	for seq, size := range j.segments {
+		j.write(fmt.Sprintf("segment %d %d\n", seq, size))
+	}
+	return j, nil
+}
+
+// reportStaleJobs lists job directories of other downloads that were interrupted,
+// and removes them with StaleClean
+func (d *Downloader) reportStaleJobs() {
+	dirs, _ := filepath.Glob(filepath.Join(d.config.OutputDir, jobPrefix+"*"))
+	for _, dir := range dirs {
+		state, err := readJournal(dir)
+		if err != nil || !state.stale() || state.url == d.sourceURL {
+			continue
+		}
+		if d.config.StaleJobs == config.StaleClean {
+			d.printf("Removing interrupted job %s (%s)\n", dir, state.url)
+			state.clean(dir)
+		} else {
+			d.printf("Found interrupted job %s (%s, %d segments saved); run it again to resume\n",
+				dir, state.url, len(state.segments))
+		}
+	}
+}
+
+// write appends to the journal, syncing it so the entry survives a crash
+func (j *job) write(entry string) {
+	j.journal.WriteString(entry)
+	j.journal.Sync()
+}
+
+// path returns where the segment with media sequence number seq is saved
+func (j *job) path(seq int) string {
+	return filepath.Join(j.dir, segmentFileName(seq))
+}
+
+// saved returns the segment seq when an earlier run saved it
+func (j *job) saved(seq int) *segmentData {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	size, ok := j.segments[seq]
+	if !ok {
+		return nil
+	}
+	return &segmentData{file: j.path(seq), size: size}
+}
+
+// recordSegment notes that segment seq is saved in the job directory
+func (j *job) recordSegment(seq int, size int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if saved, ok := j.segments[seq]; ok && saved == size {
+		return
+	}
+	j.segments[seq] = size
+	j.write(fmt.Sprintf("segment %d %d\n", seq, size))
+}
+
+// recordTemp notes a temporary output file so an interrupted run can remove it
+func (j *job) recordTemp(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// The next run may start from another working directory
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	j.temps = append(j.temps, name)
+	j.write(fmt.Sprintf("temp %s\n", name))
+}
+
+// finish marks the job complete. Without keep the files it created and the
+// directory go away, otherwise the saved segments stay for a later run.
+func (j *job) finish(keep bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if keep {
+		j.write("done\n")
+		j.journal.Close()
+		return
+	}
+
+	j.journal.Close()
+	state := &jobState{segments: j.segments, temps: j.temps}
+	state.clean(j.dir)
+}
+
+// abandonJob closes the journal of a failed download. Saved segments stay for a
+// resume, a job directory with nothing worth keeping is removed.
+func (d *Downloader) abandonJob() {
+	j := d.job
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.journal.Close()
+
+	kept := 0
+	for seq := range j.segments {
+		if _, err := os.Stat(j.path(seq)); err == nil {
+			kept++
+		}
+	}
+	if kept > 0 {
+		d.printf("%d downloaded segments kept in %s, run the same command again to resume\n", kept, j.dir)
+		return
+	}
+	state := &jobState{segments: j.segments, temps: j.temps}
+	state.clean(j.dir)
+}
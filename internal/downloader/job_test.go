@@ -0,0 +1,224 @@
+package downloader
+
+import (
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"m3u8-downloader/internal/config"
+)
+
+const (
+	deadPID    = 1 << 30 // A process ID no running process has
+	testURL    = "https://example.com/playlist.m3u8"
+	testOutput = "out.ts"
+)
+
+// writeFile creates a file with size bytes
+func writeFile(t *testing.T, name string, size int) {
+	t.Helper()
+	if err := os.WriteFile(name, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pidLine is the journal entry of a run by process pid
+func pidLine(pid int) string {
+	return "pid " + strconv.Itoa(pid) + " 2024-05-01T18:00:00Z"
+}
+
+// exists reports whether a file is still there
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// newJobDir creates the job directory of url and testOutput in dir with the given journal lines
+func newJobDir(t *testing.T, dir, url string, lines ...string) string {
+	t.Helper()
+	jobDir := filepath.Join(dir, jobDirName(url, testOutput))
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	journal := strings.Join(append([]string{"url " + url, "output " + testOutput}, lines...), "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(jobDir, journalName), []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return jobDir
+}
+
+// jobDownloader creates a downloader working in dir
+func jobDownloader(dir, stale string) *Downloader {
+	return &Downloader{
+		config:    &config.Config{OutputDir: dir, StaleJobs: stale},
+		sourceURL: testURL,
+		started:   time.Now(),
+		log:       io.Discard,
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	dir := newJobDir(t, t.TempDir(), testURL,
+		pidLine(100),
+		"segment 5 1000",
+		"segment 6 2000",
+		"segment x 10",
+		"temp /tmp/out.ts.tmp",
+		"done",
+		pidLine(200), // A later run took the job over
+		"segment 6 2500",
+	)
+
+	state, err := readJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.url != testURL || state.output != testOutput || state.pid != 200 || state.done {
+		t.Errorf("got url %q output %q pid %d done %v", state.url, state.output, state.pid, state.done)
+	}
+	if want := map[int]int64{5: 1000, 6: 2500}; !maps.Equal(state.segments, want) {
+		t.Errorf("got segments %v, want %v", state.segments, want)
+	}
+	if len(state.temps) != 1 || state.temps[0] != "/tmp/out.ts.tmp" {
+		t.Errorf("got temps %v", state.temps)
+	}
+}
+
+func TestOpenJobResume(t *testing.T) {
+	dir := t.TempDir()
+	temp := filepath.Join(dir, "out.ts.tmp")
+	jobDir := newJobDir(t, dir, testURL,
+		pidLine(deadPID),
+		"segment 1 100",
+		"segment 2 200",
+		"segment 3 300",
+		"temp "+temp,
+	)
+	writeFile(t, filepath.Join(jobDir, segmentFileName(1)), 100)
+	writeFile(t, filepath.Join(jobDir, segmentFileName(2)), 150) // Cut short
+	writeFile(t, temp, 10)
+
+	j, err := jobDownloader(dir, config.StaleResume).openJob(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.journal.Close()
+
+	if want := map[int]int64{1: 100}; !maps.Equal(j.segments, want) {
+		t.Errorf("reused segments %v, want %v", j.segments, want)
+	}
+	if exists(temp) {
+		t.Error("partial output of the earlier run was not removed")
+	}
+
+	// The new journal lists only what is still valid
+	state, err := readJournal(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{1: 100}; !maps.Equal(state.segments, want) || state.pid != os.Getpid() {
+		t.Errorf("new journal has segments %v and pid %d", state.segments, state.pid)
+	}
+}
+
+func TestOpenJobStaleClean(t *testing.T) {
+	dir := t.TempDir()
+	unrelated := filepath.Join(dir, "notes.txt")
+	writeFile(t, unrelated, 10)
+
+	jobDir := newJobDir(t, dir, testURL, pidLine(deadPID), "segment 1 100")
+	writeFile(t, filepath.Join(jobDir, segmentFileName(1)), 100)
+	strayInJob := filepath.Join(jobDir, "keep.me")
+	writeFile(t, strayInJob, 10)
+
+	// An interrupted job of another playlist in the same directory
+	otherDir := newJobDir(t, dir, "https://example.com/other.m3u8", pidLine(deadPID), "segment 7 100")
+	writeFile(t, filepath.Join(otherDir, segmentFileName(7)), 100)
+	writeFile(t, filepath.Join(otherDir, segmentFileName(8)), 100) // Not in its journal
+
+	j, err := jobDownloader(dir, config.StaleClean).openJob(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.journal.Close()
+
+	if len(j.segments) != 0 {
+		t.Errorf("reused segments %v with StaleClean", j.segments)
+	}
+	for _, name := range []string{filepath.Join(jobDir, segmentFileName(1)), filepath.Join(otherDir, segmentFileName(7)), filepath.Join(otherDir, journalName)} {
+		if exists(name) {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+	for _, name := range []string{unrelated, strayInJob, filepath.Join(otherDir, segmentFileName(8))} {
+		if !exists(name) {
+			t.Errorf("%s was removed but is not listed in a journal", name)
+		}
+	}
+}
+
+func TestOpenJobLiveProcess(t *testing.T) {
+	dir := t.TempDir()
+	newJobDir(t, dir, testURL, pidLine(os.Getppid()), "segment 1 100")
+
+	for _, stale := range []string{config.StaleResume, config.StaleClean} {
+		if j, err := jobDownloader(dir, stale).openJob(testOutput); err == nil {
+			j.journal.Close()
+			t.Errorf("%s: second run was not refused while the first is running", stale)
+		}
+	}
+
+	// A finished job does not block, even when its process is still around
+	newJobDir(t, dir, testURL, pidLine(os.Getppid()), "done")
+	j, err := jobDownloader(dir, config.StaleResume).openJob(testOutput)
+	if err != nil {
+		t.Fatalf("finished job blocked a new run: %v", err)
+	}
+	j.journal.Close()
+}
+
+func TestAbandonJob(t *testing.T) {
+	dir := t.TempDir()
+	d := jobDownloader(dir, config.StaleResume)
+
+	// Nothing saved, the directory goes away
+	j, err := d.openJob(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := filepath.Join(dir, "out.ts.tmp")
+	writeFile(t, temp, 10)
+	j.recordTemp(temp)
+	d.job = j
+	d.abandonJob()
+	if exists(j.dir) || exists(temp) {
+		t.Error("job directory or temporary output left behind with no segments saved")
+	}
+
+	// Saved segments are kept for a resume
+	j, err = d.openJob(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, j.path(3), 100)
+	j.recordSegment(3, 100)
+	d.job = j
+	d.abandonJob()
+	if !exists(j.path(3)) {
+		t.Fatal("saved segment removed")
+	}
+
+	resumed, err := d.openJob(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.journal.Close()
+	if want := map[int]int64{3: 100}; !maps.Equal(resumed.segments, want) {
+		t.Errorf("resumed with segments %v, want %v", resumed.segments, want)
+	}
+}
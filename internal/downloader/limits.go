@@ -0,0 +1,165 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// hostLimiter caps the concurrent segment requests to each host
+type hostLimiter struct {
+	mu      sync.Mutex
+	limit   int // Requests per host, unlimited when zero
+	active  map[string]int
+	changed chan struct{} // Closed and replaced when a request ends or the limit changes
+}
+
+// newHostLimiter creates a limiter allowing limit requests per host, unlimited when zero
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, active: make(map[string]int), changed: make(chan struct{})}
+}
+
+// acquire waits until host has a free slot or ctx is done
+func (h *hostLimiter) acquire(ctx context.Context, host string) error {
+	for {
+		h.mu.Lock()
+		if h.limit <= 0 || h.active[host] < h.limit {
+			h.active[host]++
+			h.mu.Unlock()
+			return nil
+		}
+		changed := h.changed
+		h.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the slot taken by acquire
+func (h *hostLimiter) release(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.active[host]--; h.active[host] <= 0 {
+		delete(h.active, host)
+	}
+	h.notify()
+}
+
+// getLimit returns the requests allowed per host, zero when unlimited
+func (h *hostLimiter) getLimit() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.limit
+}
+
+// setLimit changes the requests allowed per host. Requests above a lowered
+// limit finish, new ones wait until their host is below it.
+func (h *hostLimiter) setLimit(limit int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.limit = max(limit, 0)
+	h.notify()
+}
+
+// notify wakes every waiting acquire, the caller holds mu
+func (h *hostLimiter) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// setRateLimit changes the bandwidth limit of the running download
+func (d *Downloader) setRateLimit(rate int64) {
+	d.rate.SetRate(rate)
+	d.printf("\nRate limit set to %s\n", describeRate(rate))
+}
+
+// setHostConnections changes the per-host request limit of the running download
+func (d *Downloader) setHostConnections(n int) {
+	d.hosts.setLimit(n)
+	if n > 0 {
+		d.printf("\nConnections per host set to %d\n", n)
+	} else {
+		d.println("\nConnections per host set to unlimited")
+	}
+}
+
+// describeRate formats a bandwidth limit for messages
+func describeRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return utils.FormatSize(uint64(rate)) + "/s"
+}
+
+// describeLimits formats a bandwidth and per-host request limit for messages
+func describeLimits(rate int64, hosts int) string {
+	perHost := "unlimited"
+	if hosts > 0 {
+		perHost = fmt.Sprint(hosts)
+	}
+	return fmt.Sprintf("rate %s, connections per host %s", describeRate(rate), perHost)
+}
+
+// requestTimeout cancels a request once it has run for its timeout, pushed back
+// by the time the rate limit holds it
+type requestTimeout struct {
+	limit time.Duration
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+	fired    bool
+	stopped  bool
+}
+
+// newRequestTimeout calls cancel when limit has passed
+func newRequestTimeout(limit time.Duration, cancel context.CancelFunc) *requestTimeout {
+	t := &requestTimeout{limit: limit, deadline: time.Now().Add(limit)}
+	t.timer = time.AfterFunc(limit, func() {
+		t.mu.Lock()
+		t.fired = true
+		t.mu.Unlock()
+		cancel()
+	})
+	return t
+}
+
+// extend moves the deadline back by d
+func (t *requestTimeout) extend(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.fired && !t.stopped {
+		t.deadline = t.deadline.Add(d)
+		t.timer.Reset(time.Until(t.deadline))
+	}
+}
+
+// stop releases the timer, later extensions no longer restart it
+func (t *requestTimeout) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	t.timer.Stop()
+}
+
+// err reports a request error caused by the timeout as a deadline, which is retried
+func (t *requestTimeout) err(err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil && t.fired {
+		return fmt.Errorf("request timed out after %v: %w", t.limit, context.DeadlineExceeded)
+	}
+	return err
+}
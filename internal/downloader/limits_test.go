@@ -0,0 +1,38 @@
+package downloader
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutStopped(t *testing.T) {
+	var cancelled atomic.Bool
+	timeout := newRequestTimeout(20*time.Millisecond, func() { cancelled.Store(true) })
+
+	// A stopped timeout stays stopped through rate limit holds, even once its
+	// deadline has passed
+	timeout.stop()
+	time.Sleep(40 * time.Millisecond)
+	timeout.extend(time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+
+	if cancelled.Load() {
+		t.Error("stopped timeout cancelled the request")
+	}
+	if err := timeout.err(nil); err != nil {
+		t.Errorf("stopped timeout reported %v", err)
+	}
+}
+
+func TestRequestTimeoutExtend(t *testing.T) {
+	var cancelled atomic.Bool
+	timeout := newRequestTimeout(20*time.Millisecond, func() { cancelled.Store(true) })
+	defer timeout.stop()
+
+	timeout.extend(time.Hour)
+	time.Sleep(40 * time.Millisecond)
+	if cancelled.Load() {
+		t.Error("extended timeout cancelled the request before its deadline")
+	}
+}
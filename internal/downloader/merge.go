@@ -0,0 +1,441 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"m3u8-downloader/internal/config"
+	"m3u8-downloader/internal/mpegts"
+)
+
+// segmentData holds a downloaded segment, in memory or spilled to a file
+type segmentData struct {
+	data []byte // In-memory contents, nil when spilled to file
+	file string
+	size int64
+	keep bool // The file stays in the job directory after merging
+}
+
+// open returns a reader over the segment contents
+func (s *segmentData) open() (io.ReadCloser, error) {
+	if s.data != nil {
+		return io.NopCloser(bytes.NewReader(s.data)), nil
+	}
+	return os.Open(s.file)
+}
+
+// remove deletes the spill file, if any, unless it is kept
+func (s *segmentData) remove() {
+	if s.file != "" && !s.keep {
+		os.Remove(s.file)
+	}
+}
+
+// mergeWriter appends segments to the output as soon as the contiguous prefix is
+// complete. Segments that finish early wait in a bounded reorder buffer, in memory
+// while the memory budget allows and in spill files otherwise, so the output never
+// needs a second full copy of the stream on disk.
+type mergeWriter struct {
+	tempName  string
+	finalName string
+	out       *os.File
+	writer    *bufio.Writer
+	total     int
+	sequences []int // Media sequence number of each segment, for messages
+	window    int   // Segments that may be in flight or buffered ahead of the write position
+	memLimit  int64 // Bytes of segment data that may be held in memory
+
+	// transform writes segment i to the output instead of a plain copy when set
+	transform func(i int, seg *segmentData, w io.Writer, buffer []byte) error
+
+	// restamp makes timestamps continuous across the segments marked in discontinuity
+	restamp       *mpegts.Restamper
+	discontinuity []bool
+
+	// Split output: a new file starts at the segments marked in partStarts, and
+	// before a segment that would grow the current file past partSize
+	partStarts []bool
+	partSize   int64
+	partBytes  int64                    // Bytes of segment data in the current part
+	partName   func(part, i int) string // Name of part number part, starting with segment i
+	part       int                      // Number of the current part, from 1
+	firsts     []int                    // Index of the first segment of each part
+	done       []string                 // Output files moved into place
+
+	exists string            // What to do when an output file already exists, see config.OutputExists
+	onTemp func(name string) // Called with each temporary output file created for a part
+
+	// checkSpace is called before each segment is appended and stops the merge
+	// while the output disk is short of space
+	checkSpace func(ctx context.Context) error
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    int // Index of the next segment to append
+	pending map[int]*segmentData
+	memUsed int64
+	err     error
+}
+
+// newMergeWriter creates the temporary output file for the segments with the
+// given media sequence numbers, or writes straight to stdout when output is "-"
+func newMergeWriter(output string, sequences []int, window int, memLimit int64) (*mergeWriter, error) {
+	var tempName string
+	out := os.Stdout
+	if output != "-" {
+		var err error
+		out, err = createTemp(output)
+		if err != nil {
+			return nil, err
+		}
+		tempName = out.Name()
+	}
+
+	m := &mergeWriter{
+		tempName:  tempName,
+		finalName: output,
+		out:       out,
+		writer:    bufio.NewWriterSize(out, downloadBufferSize),
+		total:     len(sequences),
+		sequences: sequences,
+		window:    window,
+		memLimit:  memLimit,
+		pending:   make(map[int]*segmentData),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m, nil
+}
+
+// waitTurn blocks until segment i fits in the reorder window
+func (m *mergeWriter) waitTurn(ctx context.Context, i int) error {
+	stop := context.AfterFunc(ctx, m.wake)
+	defer stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i >= m.next+m.window && m.err == nil && ctx.Err() == nil {
+		m.cond.Wait()
+	}
+
+	if m.err != nil {
+		return m.err
+	}
+	return ctx.Err()
+}
+
+// wake rechecks every waiter's condition
+func (m *mergeWriter) wake() {
+	m.mu.Lock()
+	m.cond.Broadcast()
+	m.mu.Unlock()
+}
+
+// reserve claims n bytes of the memory budget, reporting false when it is exhausted
+func (m *mergeWriter) reserve(n int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.memUsed+n > m.memLimit {
+		return false
+	}
+	m.memUsed += n
+	return true
+}
+
+// release returns n bytes to the memory budget
+func (m *mergeWriter) release(n int64) {
+	m.mu.Lock()
+	m.memUsed -= n
+	m.mu.Unlock()
+}
+
+// deliver hands a downloaded segment to the writer
+func (m *mergeWriter) deliver(i int, seg *segmentData) {
+	m.mu.Lock()
+	m.pending[i] = seg
+	m.cond.Broadcast()
+	m.mu.Unlock()
+}
+
+// run appends segments in order until all are written, ctx is done or a write fails
+func (m *mergeWriter) run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, m.wake)
+	defer stop()
+
+	buffer := make([]byte, mergeBufferSize)
+
+	for {
+		m.mu.Lock()
+		for m.next < m.total && m.pending[m.next] == nil && ctx.Err() == nil {
+			m.cond.Wait()
+		}
+		if m.next == m.total {
+			m.mu.Unlock()
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		seg := m.pending[m.next]
+		delete(m.pending, m.next)
+		m.mu.Unlock()
+
+		var err error
+		if m.checkSpace != nil {
+			err = m.checkSpace(ctx)
+		}
+		if err == nil {
+			err = m.append(m.next, seg, buffer)
+		}
+
+		m.mu.Lock()
+		if seg.data != nil {
+			m.memUsed -= seg.size
+		}
+		if err != nil {
+			seg.remove()
+		}
+		if isBrokenPipe(err) {
+			m.err = ErrBrokenPipe
+		} else if err != nil {
+			m.err = fmt.Errorf("error appending segment %d: %w", m.sequences[m.next], err)
+		} else {
+			m.next++
+		}
+		m.cond.Broadcast()
+		m.mu.Unlock()
+
+		if err != nil {
+			return m.err
+		}
+	}
+}
+
+// append writes segment i to the output and drops its spill file
+func (m *mergeWriter) append(i int, seg *segmentData, buffer []byte) error {
+	if m.part > 0 && i > 0 && (m.partStarts != nil && m.partStarts[i] ||
+		m.partSize > 0 && m.partBytes > 0 && m.partBytes+seg.size > m.partSize) {
+		if err := m.nextPart(i); err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = m.writer
+	if m.restamp != nil {
+		if err := m.restamp.StartSegment(m.discontinuity[i]); err != nil {
+			return err
+		}
+		out = m.restamp
+	}
+
+	var err error
+	if m.transform != nil {
+		err = m.transform(i, seg, out, buffer)
+	} else {
+		err = copySegment(seg, out, buffer)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.partBytes += seg.size
+	seg.remove()
+	return nil
+}
+
+// copySegment copies a segment to w unchanged
+func copySegment(seg *segmentData, w io.Writer, buffer []byte) error {
+	in, err := seg.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.CopyBuffer(w, in, buffer)
+	return err
+}
+
+// splitAt writes the output as numbered parts named by name. A new part starts at
+// each segment marked in starts and, when size is positive, before the current part
+// would grow past size bytes.
+func (m *mergeWriter) splitAt(starts []bool, size int64, name func(part, i int) string) error {
+	if m.tempName == "" {
+		return fmt.Errorf("output written to stdout cannot be split")
+	}
+	m.partStarts = starts
+	m.partSize = size
+	m.partName = name
+	m.part = 1
+	m.firsts = []int{0}
+	m.finalName = name(m.part, 0)
+	return nil
+}
+
+// nextPart moves the current part into place and starts writing the next one with segment i
+func (m *mergeWriter) nextPart(i int) error {
+	if m.restamp != nil {
+		if err := m.restamp.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := m.writer.Flush(); err != nil {
+		return err
+	}
+	if err := m.closeOutput(); err != nil {
+		return err
+	}
+
+	m.part++
+	name := m.partName(m.part, i)
+	if slices.Contains(m.done, name) {
+		return fmt.Errorf("part %d would overwrite %s, the name template needs {index}", m.part, name)
+	}
+	m.finalName = name
+	m.firsts = append(m.firsts, i)
+	m.partBytes = 0
+
+	out, err := createTemp(m.finalName)
+	if err != nil {
+		return err
+	}
+	m.tempName = out.Name()
+	m.out = out
+	if m.onTemp != nil {
+		m.onTemp(m.tempName)
+	}
+	m.writer.Reset(out)
+	if m.restamp != nil {
+		// Each part is a stream of its own
+		m.restamp = mpegts.NewRestamper(m.writer)
+	}
+	return nil
+}
+
+// finish flushes the output and moves it into place
+func (m *mergeWriter) finish() error {
+	var err error
+	if m.restamp != nil {
+		err = m.restamp.Flush()
+	}
+	if err == nil {
+		err = m.writer.Flush()
+	}
+	if err != nil {
+		m.abort()
+		if isBrokenPipe(err) {
+			return ErrBrokenPipe
+		}
+		return err
+	}
+
+	if m.tempName == "" {
+		return nil
+	}
+
+	if err := m.closeOutput(); err != nil {
+		m.abort()
+		return err
+	}
+	return nil
+}
+
+// closeOutput closes the current output file and renames it to its final name
+func (m *mergeWriter) closeOutput() error {
+	if err := m.out.Close(); err != nil {
+		os.Remove(m.tempName)
+		return err
+	}
+
+	if _, err := os.Stat(m.finalName); err == nil {
+		switch m.exists {
+		case config.ExistsSkip:
+			os.Remove(m.tempName)
+			return fmt.Errorf("output file %s already exists", m.finalName)
+		case config.ExistsSuffix:
+			m.finalName = freeName(m.finalName)
+		default:
+			if err := os.Remove(m.finalName); err != nil {
+				os.Remove(m.tempName)
+				return fmt.Errorf("failed to remove existing output file: %w", err)
+			}
+		}
+	}
+
+	if err := os.Rename(m.tempName, m.finalName); err != nil {
+		os.Remove(m.tempName)
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	m.done = append(m.done, m.finalName)
+	return nil
+}
+
+// createTemp creates a uniquely named temporary file next to the output, so runs
+// writing the same output name do not share it
+func createTemp(output string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes the file private, the output is an ordinary file
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// freeName returns the first of name_1.ext, name_2.ext, ... that does not exist
+func freeName(name string) string {
+	ext := filepath.Ext(name)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), n, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// abort discards the partial output and any buffered segments
+func (m *mergeWriter) abort() {
+	if m.tempName != "" {
+		m.out.Close()
+		os.Remove(m.tempName)
+	}
+	for _, name := range m.done {
+		os.Remove(name)
+	}
+	m.done = nil
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, seg := range m.pending {
+		seg.remove()
+		delete(m.pending, i)
+	}
+}
+
+// discontinuities marks the segments whose timestamps do not follow the previous
+// segment: those tagged #EXT-X-DISCONTINUITY and those after skipped segments
+func discontinuities(segments []Segment) ([]bool, int) {
+	marks := make([]bool, len(segments))
+	n := 0
+	for i := 1; i < len(segments); i++ {
+		if segments[i].Discontinuity || segments[i].Sequence != segments[i-1].Sequence+1 {
+			marks[i] = true
+			n++
+		}
+	}
+	return marks, n
+}
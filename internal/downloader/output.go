@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"m3u8-downloader/internal/config"
+)
+
+// dateFormat is how dates appear in file names
+const dateFormat = "20060102T150405Z"
+
+// expandTemplate replaces the {key} placeholders of a file name template
+func expandTemplate(template string, vars map[string]string) string {
+	pairs := make([]string, 0, 2*len(vars))
+	for key, value := range vars {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// sanitizeName makes a value safe to use inside a file name on any platform
+func sanitizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.Trim(s, " .")
+}
+
+// isOutputTemplate reports whether the output name needs filling in
+func isOutputTemplate(output string) bool {
+	return strings.Contains(output, "{") && output != "-"
+}
+
+// outputVars returns the template values describing the playlist and the first
+// segment of the output
+func (d *Downloader) outputVars(segments []Segment) map[string]string {
+	vars := map[string]string{
+		"seq":  strconv.Itoa(segments[0].Sequence),
+		"date": d.started.UTC().Format(dateFormat),
+	}
+
+	if u, err := url.Parse(d.sourceURL); err == nil {
+		path := strings.TrimSuffix(strings.Trim(u.Path, "/"), filepath.Ext(u.Path))
+		vars["host"] = sanitizeName(u.Hostname())
+		vars["path"] = sanitizeName(strings.ReplaceAll(path, "/", "_"))
+		vars["name"] = sanitizeName(filepath.Base("/" + path))
+	}
+
+	if v := d.variant; v != nil {
+		vars["resolution"] = sanitizeName(v.Resolution)
+		vars["bandwidth"] = strconv.Itoa(v.Bandwidth)
+		vars["codecs"] = sanitizeName(strings.ReplaceAll(v.Codecs, ",", "+"))
+	}
+	if pdt := segments[0].ProgramDateTime; !pdt.IsZero() {
+		vars["pdt"] = pdt.UTC().Format(dateFormat)
+	}
+
+	// Placeholders without a value are left empty
+	for _, key := range []string{"host", "path", "name", "resolution", "bandwidth", "codecs", "pdt"} {
+		if _, ok := vars[key]; !ok {
+			vars[key] = ""
+		}
+	}
+	return vars
+}
+
+// resolveOutput fills in the output name template. {index} becomes the lowest
+// number giving a name that is not taken yet.
+func (d *Downloader) resolveOutput(segments []Segment) (string, error) {
+	name := expandTemplate(d.config.Output, d.outputVars(segments))
+
+	if strings.Contains(name, "{index}") {
+		for n := 1; ; n++ {
+			candidate := strings.ReplaceAll(name, "{index}", strconv.Itoa(n))
+			if !fileExists(d.firstOutputFile(candidate, segments)) {
+				name = candidate
+				break
+			}
+		}
+	}
+
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+	return name, nil
+}
+
+// firstOutputFile returns the name of the first file written for output
+func (d *Downloader) firstOutputFile(output string, segments []Segment) string {
+	if !d.splitting() {
+		return output
+	}
+	return expandPartName(d.config.SplitTemplate, output, 1, segments[0], 0)
+}
+
+// outputTaken reports whether the download should be skipped because its output exists
+func (d *Downloader) outputTaken(segments []Segment) bool {
+	return d.config.OutputExists == config.ExistsSkip && d.config.Output != "-" &&
+		fileExists(d.firstOutputFile(d.config.Output, segments))
+}
+
+// fileExists reports whether name exists
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -3,19 +3,62 @@ package downloader
 import (
 	"bufio"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"m3u8-downloader/pkg/utils"
 )
 
-// ParseBandwidth extracts bandwidth value from stream info
-func parseBandwidth(streamInfo string) int {
-	bandwidth := 0
-	if idx := strings.Index(streamInfo, "BANDWIDTH="); idx != -1 {
-		part := streamInfo[idx+10:]
-		fmt.Sscanf(part, "%d", &bandwidth)
+// Variant is a stream listed in a master playlist
+type Variant struct {
+	URL        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+	FrameRate  string
+	PathwayID  string // Content steering pathway, empty when not steered
+}
+
+// ContentSteering holds the #EXT-X-CONTENT-STEERING tag of a master playlist
+type ContentSteering struct {
+	ServerURI string
+	PathwayID string // Pathway to start with
+}
+
+// parseAttributes parses an attribute list such as BANDWIDTH=1,CODECS="a,b"
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+
+	for list != "" {
+		key, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[strings.TrimSpace(key)] = value
+		list = rest
 	}
-	return bandwidth
+
+	return attrs
+}
+
+// groupKey identifies variants that are redundant copies of the same rendition
+func (v Variant) groupKey() string {
+	return fmt.Sprintf("%d|%s|%s|%s", v.Bandwidth, v.Resolution, v.Codecs, v.FrameRate)
 }
 
 // IsMasterPlaylist checks if the content is a master playlist
@@ -23,52 +66,196 @@ func IsMasterPlaylist(content string) bool {
 	return strings.Contains(content, "#EXT-X-STREAM-INF")
 }
 
-// SelectVariantStream selects the highest bandwidth stream from a master playlist
-func SelectVariantStream(content, baseURL string) (string, error) {
-	var highestBandwidth int
-	var selectedURL string
+// ParseMasterPlaylist extracts the variant streams and content steering tag
+func ParseMasterPlaylist(content, baseURL string) ([]Variant, *ContentSteering, error) {
+	var variants []Variant
+	var steering *ContentSteering
 
 	scanner := bufio.NewScanner(strings.NewReader(content))
-	var streamInfo string
+	var streamInfo map[string]string
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
-			streamInfo = line
-		} else if streamInfo != "" && !strings.HasPrefix(line, "#") {
-			bandwidth := parseBandwidth(streamInfo)
-			if bandwidth > highestBandwidth {
-				highestBandwidth = bandwidth
-				selectedURL = line
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			streamInfo = parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		case strings.HasPrefix(line, "#EXT-X-CONTENT-STEERING:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-CONTENT-STEERING:"))
+			steering = &ContentSteering{
+				ServerURI: utils.ResolveURL(baseURL, attrs["SERVER-URI"]),
+				PathwayID: attrs["PATHWAY-ID"],
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		case streamInfo != nil:
+			// Check if the URL is relative
+			if !strings.HasPrefix(line, "http") {
+				line = utils.ResolveURL(baseURL, line)
 			}
-			streamInfo = ""
+
+			bandwidth := 0
+			fmt.Sscanf(streamInfo["BANDWIDTH"], "%d", &bandwidth)
+			variants = append(variants, Variant{
+				URL:        line,
+				Bandwidth:  bandwidth,
+				Resolution: streamInfo["RESOLUTION"],
+				Codecs:     streamInfo["CODECS"],
+				FrameRate:  streamInfo["FRAME-RATE"],
+				PathwayID:  streamInfo["PATHWAY-ID"],
+			})
+			streamInfo = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return variants, steering, nil
+}
+
+// SelectVariantGroup selects the highest bandwidth stream from a master playlist
+// together with its redundant copies on other hosts or pathways, in playlist order
+func SelectVariantGroup(content, baseURL string) ([]Variant, *ContentSteering, error) {
+	variants, steering, err := ParseMasterPlaylist(content, baseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var best *Variant
+	for i := range variants {
+		if best == nil || variants[i].Bandwidth > best.Bandwidth {
+			best = &variants[i]
 		}
 	}
 
-	if selectedURL == "" {
-		return "", fmt.Errorf("no valid streams found in master playlist")
+	if best == nil {
+		return nil, nil, fmt.Errorf("no valid streams found in master playlist")
 	}
 
-	// Check if the URL is relative
-	if !strings.HasPrefix(selectedURL, "http") {
-		selectedURL = utils.ResolveURL(baseURL, selectedURL)
+	var group []Variant
+	for _, v := range variants {
+		if v.groupKey() == best.groupKey() {
+			group = append(group, v)
+		}
 	}
 
-	fmt.Printf("Selected stream with bandwidth: %d\n", highestBandwidth)
-	return selectedURL, nil
+	return group, steering, nil
 }
 
-// ParseSegments extracts segment URLs from playlist content
-func ParseSegments(content, baseURL string) ([]string, error) {
-	var segments []string
+// SelectVariantStream selects the highest bandwidth stream from a master playlist
+func SelectVariantStream(content, baseURL string) (string, error) {
+	group, _, err := SelectVariantGroup(content, baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Selected stream with bandwidth: %d\n", group[0].Bandwidth)
+	return group[0].URL, nil
+}
+
+// DateRange is an #EXT-X-DATERANGE tag
+type DateRange struct {
+	ID       string
+	Class    string
+	Title    string    // X-TITLE client attribute, empty when absent
+	Start    time.Time // Zero when START-DATE is missing or invalid
+	Duration float64   // DURATION or PLANNED-DURATION in seconds, 0 when absent
+}
+
+// parseDateRange parses the attribute list of an #EXT-X-DATERANGE tag
+func parseDateRange(list string) DateRange {
+	attrs := parseAttributes(list)
+	start, _ := parseProgramDateTime(attrs["START-DATE"])
+	duration, err := strconv.ParseFloat(attrs["DURATION"], 64)
+	if err != nil {
+		duration, _ = strconv.ParseFloat(attrs["PLANNED-DURATION"], 64)
+	}
+	return DateRange{
+		ID:       attrs["ID"],
+		Class:    attrs["CLASS"],
+		Title:    attrs["X-TITLE"],
+		Start:    start,
+		Duration: duration,
+	}
+}
+
+// Segment is a media segment along with the playlist tags that describe it
+type Segment struct {
+	URL      string
+	Sequence int     // Media sequence number
+	Duration float64 // Duration from #EXTINF in seconds
+
+	// Discontinuity is set by #EXT-X-DISCONTINUITY: timestamps may restart at this segment
+	Discontinuity bool
+
+	// Wall-clock start from #EXT-X-PROGRAM-DATE-TIME, extrapolated from the durations
+	// of earlier segments when the tag is not repeated. Zero when the playlist has none.
+	ProgramDateTime time.Time
+
+	// AdBreak is set for segments inside an ad break marked by EXT-X-CUE-OUT,
+	// EXT-X-SCTE35 or EXT-X-DATERANGE. The first segment of a break carries the marker.
+	AdBreak   bool
+	AdMarker  string  // Tag that opened the break
+	AdPlanned float64 // Break duration announced by the marker in seconds, 0 when unknown
+
+	// ExplicitDateTime is set when #EXT-X-PROGRAM-DATE-TIME was given for this segment
+	ExplicitDateTime bool
+	// InvalidDateTime is the value of an #EXT-X-PROGRAM-DATE-TIME given for this
+	// segment that could not be parsed; the date-time is inferred instead
+	InvalidDateTime string
+	DateRanges      []DateRange // #EXT-X-DATERANGE tags preceding the segment
+}
+
+// ParseMediaPlaylist extracts segments with their media sequence numbers
+func ParseMediaPlaylist(content, baseURL string) ([]Segment, error) {
+	var segments []Segment
 	scanner := bufio.NewScanner(strings.NewReader(content))
 
+	sequence := 0
+	var duration float64
+	var programDateTime time.Time
+	discontinuity := false
+	explicitDateTime := false
+	invalidDateTime := ""
+	var dateRanges []DateRange
+	var cues cueState
+
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
 
-		// Skip empty lines and comments/tags
-		if line == "" || strings.HasPrefix(line, "#") {
+		if strings.HasPrefix(line, "#") {
+			switch {
+			case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+				fmt.Sscanf(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), "%d", &sequence)
+			case strings.HasPrefix(line, "#EXTINF:"):
+				fmt.Sscanf(strings.TrimPrefix(line, "#EXTINF:"), "%g", &duration)
+			case line == "#EXT-X-DISCONTINUITY":
+				discontinuity = true
+			case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+				value := strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")
+				pdt, err := parseProgramDateTime(value)
+				if err != nil {
+					// Keep inferring from the last valid tag
+					invalidDateTime = value
+					break
+				}
+				programDateTime = pdt
+				explicitDateTime = true
+				invalidDateTime = ""
+			case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+				dateRanges = append(dateRanges, parseDateRange(strings.TrimPrefix(line, "#EXT-X-DATERANGE:")))
+				cues.tag(line, len(segments))
+			default:
+				cues.tag(line, len(segments))
+			}
 			continue
 		}
 
@@ -77,12 +264,62 @@ func ParseSegments(content, baseURL string) ([]string, error) {
 			line = utils.ResolveURL(baseURL, line)
 		}
 
-		segments = append(segments, line)
+		seg := Segment{
+			URL:             line,
+			Sequence:        sequence,
+			Duration:        duration,
+			Discontinuity:   discontinuity,
+			ProgramDateTime: programDateTime,
+
+			ExplicitDateTime: explicitDateTime,
+			InvalidDateTime:  invalidDateTime,
+			DateRanges:       dateRanges,
+		}
+		cues.segment(&seg)
+		segments = append(segments, seg)
+		sequence++
+		if !programDateTime.IsZero() {
+			programDateTime = programDateTime.Add(time.Duration(duration * float64(time.Second)))
+		}
+		duration = 0
+		discontinuity = false
+		explicitDateTime = false
+		invalidDateTime = ""
+		dateRanges = nil
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
+	cues.finish(segments)
 	return segments, nil
 }
+
+// parseProgramDateTime parses an ISO 8601 date-time, which players also accept
+// without the colon in the zone offset
+func parseProgramDateTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, nil
+	}
+	if t, err2 := time.Parse("2006-01-02T15:04:05.999999999Z0700", value); err2 == nil {
+		return t, nil
+	}
+	return time.Time{}, err
+}
+
+// ParseSegments extracts segment URLs from playlist content
+func ParseSegments(content, baseURL string) ([]string, error) {
+	segments, err := ParseMediaPlaylist(content, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, len(segments))
+	for i, seg := range segments {
+		urls[i] = seg.URL
+	}
+
+	return urls, nil
+}
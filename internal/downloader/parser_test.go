@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+const invalidDateTimePlaylist = `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T18:00:00Z
+#EXTINF:10,
+a.ts
+#EXTINF:10,
+b.ts
+#EXT-X-PROGRAM-DATE-TIME:yesterday
+#EXTINF:10,
+c.ts
+#EXTINF:10,
+d.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T18:00:40Z
+#EXTINF:10,
+e.ts
+`
+
+func TestParseMediaPlaylistInvalidDateTime(t *testing.T) {
+	segments, err := ParseMediaPlaylist(invalidDateTimePlaylist, "https://example.com/")
+	if err != nil {
+		t.Fatalf("ParseMediaPlaylist: %v", err)
+	}
+	if len(segments) != 5 {
+		t.Fatalf("got %d segments, want 5", len(segments))
+	}
+
+	start := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	for i, seg := range segments {
+		if want := start.Add(time.Duration(i) * 10 * time.Second); !seg.ProgramDateTime.Equal(want) {
+			t.Errorf("segment %d: date-time %v, want %v", seg.Sequence, seg.ProgramDateTime, want)
+		}
+		wantInvalid := ""
+		if i == 2 {
+			wantInvalid = "yesterday"
+		}
+		if seg.InvalidDateTime != wantInvalid {
+			t.Errorf("segment %d: invalid date-time %q, want %q", seg.Sequence, seg.InvalidDateTime, wantInvalid)
+		}
+	}
+}
+
+func TestClipOffsetInvalidDateTime(t *testing.T) {
+	segments, err := ParseMediaPlaylist(invalidDateTimePlaylist, "https://example.com/")
+	if err != nil {
+		t.Fatalf("ParseMediaPlaylist: %v", err)
+	}
+	starts := make([]time.Duration, len(segments))
+	for i := range segments {
+		starts[i] = time.Duration(i) * 10 * time.Second
+	}
+
+	tests := []struct {
+		wall string
+		want time.Duration
+		err  bool
+	}{
+		{wall: "2024-05-01T18:00:05Z", want: 5 * time.Second},
+		{wall: "2024-05-01T18:00:15Z", want: 15 * time.Second},
+		// Inferred across the invalid tag
+		{wall: "2024-05-01T18:00:25Z", err: true},
+		{wall: "2024-05-01T18:00:35Z", err: true},
+		// A valid tag again
+		{wall: "2024-05-01T18:00:45Z", want: 45 * time.Second},
+	}
+
+	for _, tt := range tests {
+		point, err := utils.ParseTimePoint(tt.wall)
+		if err != nil {
+			t.Fatalf("ParseTimePoint(%q): %v", tt.wall, err)
+		}
+		got, err := clipOffset(point, segments, starts)
+		if tt.err {
+			if err == nil {
+				t.Errorf("clipOffset(%s) = %v, want an error", tt.wall, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("clipOffset(%s): %v", tt.wall, err)
+		} else if got != tt.want {
+			t.Errorf("clipOffset(%s) = %v, want %v", tt.wall, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isBrokenPipe reports whether err comes from writing to a closed pipe
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNoData is ERROR_NO_DATA, returned when the reading end of a pipe is being closed
+const errorNoData syscall.Errno = 232
+
+// isBrokenPipe reports whether err comes from writing to a closed pipe
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.ERROR_BROKEN_PIPE) || errors.Is(err, errorNoData)
+}
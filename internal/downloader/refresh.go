@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// playlistSource tracks where segment URLs come from so expired ones can be
+// re-signed and failing ones fetched from a redundant pathway
+type playlistSource struct {
+	masterURL string // Empty when the input was a media playlist
+
+	loadMu sync.Mutex // Serializes the first load of each pathway playlist
+
+	mu       sync.Mutex // Guards the fields below
+	pathways []*pathway
+	priority []string // Content steering PATHWAY-PRIORITY
+	health   map[string]*hostStats
+}
+
+// newPlaylistSource tracks the pathways of the selected variant
+func newPlaylistSource(masterURL string, pathways []*pathway) *playlistSource {
+	return &playlistSource{
+		masterURL: masterURL,
+		pathways:  pathways,
+		health:    make(map[string]*hostStats),
+	}
+}
+
+// isExpired reports whether err looks like a signed URL or token that is no longer valid
+func isExpired(err error) bool {
+	var httpErr *utils.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// refreshSegmentURL re-fetches the pathway's playlist after staleURL stopped working
+// and returns the newly signed URL for the same sequence number. The playlist is
+// fetched without holding a lock, so a slow refresh holds up no other worker.
+func (d *Downloader) refreshSegmentURL(p *pathway, seg Segment, staleURL string) (string, error) {
+	// Another worker already refreshed the playlist since this URL was handed out
+	d.source.mu.Lock()
+	current := p.urls[seg.Sequence]
+	loads := p.loads
+	d.source.mu.Unlock()
+	if current != "" && current != staleURL {
+		return current, nil
+	}
+
+	d.printf("\nSegment %d was rejected, refreshing playlist for new URLs...\n", seg.Sequence)
+	segments, err := d.fetchMediaSegments(p)
+	if err != nil {
+		return "", fmt.Errorf("error refreshing playlist: %w", err)
+	}
+
+	// A refresh that finished in the meantime is kept unless it still lists the
+	// rejected URL
+	d.source.mu.Lock()
+	if p.loads == loads || p.urls[seg.Sequence] == staleURL {
+		p.setSegments(segments)
+	}
+	current, ok := p.urls[seg.Sequence]
+	d.source.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("segment %d is no longer listed in the playlist", seg.Sequence)
+	}
+	if current == staleURL {
+		return "", fmt.Errorf("refreshed playlist still lists the rejected URL for segment %d", seg.Sequence)
+	}
+
+	return current, nil
+}
+
+// fetchMediaSegments fetches a pathway's media playlist, going back to the master
+// playlist for a new variant URL when the media playlist URL itself expired
+func (d *Downloader) fetchMediaSegments(p *pathway) ([]Segment, error) {
+	d.source.mu.Lock()
+	mediaURL := p.mediaURL
+	d.source.mu.Unlock()
+
+	content, err := d.fetchPlaylist(mediaURL)
+	if err != nil && isExpired(err) && d.source.masterURL != "" {
+		mediaURL, err = d.reselectPathway(p)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err = d.fetchPlaylist(mediaURL)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := utils.GetBaseURL(mediaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseMediaPlaylist(content, baseURL)
+}
+
+// reselectPathway re-fetches the master playlist and returns the pathway's new media playlist URL
+func (d *Downloader) reselectPathway(p *pathway) (string, error) {
+	masterContent, err := d.fetchPlaylist(d.source.masterURL)
+	if err != nil {
+		return "", err
+	}
+
+	masterBase, err := utils.GetBaseURL(d.source.masterURL)
+	if err != nil {
+		return "", err
+	}
+
+	group, _, err := SelectVariantGroup(masterContent, masterBase)
+	if err != nil {
+		return "", err
+	}
+
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+
+	fresh := newPathways(group)
+	for _, f := range fresh {
+		if f.id == p.id {
+			p.mediaURL = f.mediaURL
+			return p.mediaURL, nil
+		}
+	}
+
+	// A single variant may have moved to another host
+	if len(fresh) == 1 && len(d.source.pathways) == 1 {
+		p.mediaURL = fresh[0].mediaURL
+		return p.mediaURL, nil
+	}
+
+	return "", fmt.Errorf("pathway %s is no longer listed in the master playlist", p.id)
+}
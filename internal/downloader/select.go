@@ -0,0 +1,43 @@
+package downloader
+
+import "fmt"
+
+// selecting reports whether only part of the segments were requested by sequence number
+func (d *Downloader) selecting() bool {
+	return len(d.config.SegmentRanges) > 0 || d.config.SegmentEvery > 1
+}
+
+// selectSegments keeps the segments whose media sequence numbers fall in the requested
+// ranges, then every Nth of those. Sequence numbers are left untouched so a partial
+// download can later be completed with the missing ones.
+func (d *Downloader) selectSegments(segments []Segment) ([]Segment, error) {
+	var selected []Segment
+	for _, seg := range segments {
+		if len(d.config.SegmentRanges) == 0 {
+			selected = append(selected, seg)
+			continue
+		}
+		for _, r := range d.config.SegmentRanges {
+			if r.Contains(seg.Sequence) {
+				selected = append(selected, seg)
+				break
+			}
+		}
+	}
+
+	if every := d.config.SegmentEvery; every > 1 {
+		var sampled []Segment
+		for i := 0; i < len(selected); i += every {
+			sampled = append(sampled, selected[i])
+		}
+		selected = sampled
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no segments match the selection (playlist has sequence numbers %d-%d)",
+			segments[0].Sequence, segments[len(segments)-1].Sequence)
+	}
+
+	d.printf("Selected %d of %d segments\n", len(selected), len(segments))
+	return selected, nil
+}
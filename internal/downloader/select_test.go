@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"io"
+	"slices"
+	"testing"
+
+	"m3u8-downloader/internal/config"
+	"m3u8-downloader/pkg/utils"
+)
+
+func TestSelectSegments(t *testing.T) {
+	var segments []Segment
+	for seq := 100; seq < 120; seq++ {
+		segments = append(segments, Segment{Sequence: seq})
+	}
+
+	tests := []struct {
+		ranges string
+		every  int
+		want   []int
+		err    bool
+	}{
+		{ranges: "105-107", want: []int{105, 106, 107}},
+		{ranges: "118-", want: []int{118, 119}},
+		{ranges: "90-101,110,200", want: []int{100, 101, 110}},
+		{every: 5, want: []int{100, 105, 110, 115}},
+		// Every Nth of the selection, not of the playlist
+		{ranges: "101-103,110-112", every: 2, want: []int{101, 103, 111}},
+		{ranges: "0-50", err: true},
+	}
+
+	for _, tt := range tests {
+		cfg := &config.Config{SegmentEvery: tt.every}
+		if tt.ranges != "" {
+			ranges, err := utils.ParseRanges(tt.ranges)
+			if err != nil {
+				t.Fatalf("ParseRanges(%q): %v", tt.ranges, err)
+			}
+			cfg.SegmentRanges = ranges
+		}
+		d := &Downloader{config: cfg, log: io.Discard}
+
+		selected, err := d.selectSegments(segments)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ranges %q every %d: selected %d segments, want an error", tt.ranges, tt.every, len(selected))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ranges %q every %d: %v", tt.ranges, tt.every, err)
+			continue
+		}
+
+		var got []int
+		for _, seg := range selected {
+			got = append(got, seg.Sequence)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ranges %q every %d: selected %v, want %v", tt.ranges, tt.every, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals adjusts the rate limit while ctx is running: SIGUSR1 halves it
+// and SIGUSR2 doubles it
+func (d *Downloader) watchSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				rate := d.rate.Rate()
+				if rate == 0 {
+					d.printf("\nReceived %v, but there is no rate limit to change; set one with -limit-rate or the control API\n", sig)
+					continue
+				}
+				if sig == syscall.SIGUSR1 {
+					d.setRateLimit(max(rate/2, 1))
+				} else {
+					d.setRateLimit(rate * 2)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package downloader
+
+import "context"
+
+// watchSignals does nothing on Windows, which has no user signals; limits are
+// changed through the control API instead
+func (d *Downloader) watchSignals(ctx context.Context) {}
@@ -0,0 +1,233 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"m3u8-downloader/internal/config"
+	"m3u8-downloader/pkg/utils"
+)
+
+const (
+	headSamples        = 3                // Segments sized with HEAD requests when the playlist gives no bandwidth
+	spaceCheckInterval = time.Second      // How often free space is queried while writing
+	lowSpacePoll       = 10 * time.Second // How often a paused download looks for freed space
+)
+
+// ErrLowSpace is returned when a download stops because a disk is nearly full
+var ErrLowSpace = errors.New("not enough free disk space")
+
+// diskFull turns a write error from a full disk into ErrLowSpace, which is not retried
+func diskFull(err error) error {
+	if isDiskFull(err) {
+		return utils.Permanent(fmt.Errorf("%w: %w", ErrLowSpace, err))
+	}
+	return err
+}
+
+// estimateSize guesses the total size of the segments from the bandwidth of the
+// selected variant, or from the sizes of a few segments otherwise
+func (d *Downloader) estimateSize(ctx context.Context, segments []Segment) (uint64, string) {
+	if d.variant != nil && d.variant.Bandwidth > 0 {
+		var duration float64
+		for _, seg := range segments {
+			duration += seg.Duration
+		}
+		return uint64(float64(d.variant.Bandwidth) * duration / 8), "BANDWIDTH"
+	}
+
+	sampled := make(map[int]bool)
+	var total int64
+	for k := 0; k < headSamples; k++ {
+		i := k * (len(segments) - 1) / (headSamples - 1)
+		if sampled[i] {
+			continue
+		}
+		sampled[i] = true
+		if size, err := d.headSize(ctx, segments[i].URL); err == nil && size > 0 {
+			total += size
+		} else {
+			return 0, ""
+		}
+	}
+	return uint64(total) / uint64(len(sampled)) * uint64(len(segments)), "sampled segment sizes"
+}
+
+// headSize returns the Content-Length of a HEAD request, -1 when the server does not say
+func (d *Downloader) headSize(ctx context.Context, url string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	req, err := d.client.NewRequest(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	req.Method = http.MethodHead
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, utils.NewHTTPError(resp)
+	}
+	return resp.ContentLength, nil
+}
+
+// checkDiskSpace compares the estimated download size with the free space in the
+// job and output directories, keeping MinFree spare
+func (d *Downloader) checkDiskSpace(ctx context.Context, segments []Segment) error {
+	estimate, source := d.estimateSize(ctx, segments)
+	if estimate == 0 {
+		d.println("Could not estimate the download size, skipping the disk space check")
+		return nil
+	}
+	d.printf("Estimated size: %s (from %s)\n", utils.FormatSize(estimate), source)
+
+	// The job directory holds every segment with -keep-segments, and otherwise
+	// at most a reorder window of segments spilled from memory
+	work := estimate
+	if !d.config.KeepSegments {
+		n := uint64(len(segments))
+		work = estimate / n * min(uint64(d.reorderWindow()), n)
+	}
+
+	var dirs []string
+	needs := make(map[string]uint64)
+	add := func(dir string, size uint64) {
+		for _, other := range dirs {
+			if utils.SameVolume(other, dir) {
+				needs[other] += size
+				return
+			}
+		}
+		dirs = append(dirs, dir)
+		needs[dir] = size
+	}
+	add(d.job.dir, work)
+	if d.config.Output != "-" {
+		add(filepath.Dir(d.config.Output), estimate)
+	}
+
+	for _, dir := range dirs {
+		free, err := utils.FreeSpace(dir)
+		if err != nil {
+			continue
+		}
+		need := needs[dir] + uint64(d.config.MinFree)
+		if free < need {
+			return fmt.Errorf("%w in %s: %s free, about %s needed including -min-free",
+				ErrLowSpace, dir, utils.FormatSize(free), utils.FormatSize(need))
+		}
+	}
+	return nil
+}
+
+// spaceGuard holds writes back while a directory has less than minFree bytes free
+type spaceGuard struct {
+	minFree uint64
+	pause   bool // Wait for space to be freed instead of failing
+	printf  func(format string, args ...any)
+
+	mu      sync.Mutex
+	checked map[string]time.Time     // Last time each directory had enough space
+	paused  map[string]chan struct{} // Closed when a paused directory is no longer polled
+}
+
+// newSpaceGuard creates the guard for a download, nil when checks are disabled
+func (d *Downloader) newSpaceGuard() *spaceGuard {
+	if !d.config.SpaceCheck {
+		return nil
+	}
+	return &spaceGuard{
+		minFree: uint64(d.config.MinFree),
+		pause:   d.config.LowSpace == config.LowSpacePause,
+		printf:  d.printf,
+		checked: make(map[string]time.Time),
+		paused:  make(map[string]chan struct{}),
+	}
+}
+
+// wait returns once dir has enough free space. When pausing, one writer polls the
+// directory while the others wait for it, until space is freed or their ctx is
+// done; otherwise ErrLowSpace is returned.
+func (g *spaceGuard) wait(ctx context.Context, dir string) error {
+	if g == nil {
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		if time.Since(g.checked[dir]) < spaceCheckInterval {
+			g.mu.Unlock()
+			return nil
+		}
+		if resumed := g.paused[dir]; resumed != nil {
+			g.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-resumed:
+			}
+			continue
+		}
+
+		free, err := utils.FreeSpace(dir)
+		if err != nil || free >= g.minFree {
+			g.checked[dir] = time.Now()
+			g.mu.Unlock()
+			return nil
+		}
+		if !g.pause {
+			g.mu.Unlock()
+			return fmt.Errorf("%w in %s: %s free, below -min-free %s",
+				ErrLowSpace, dir, utils.FormatSize(free), utils.FormatSize(g.minFree))
+		}
+		resumed := make(chan struct{})
+		g.paused[dir] = resumed
+		g.mu.Unlock()
+
+		g.printf("\nLow disk space in %s: %s free, below -min-free %s. Paused until space is freed...\n",
+			dir, utils.FormatSize(free), utils.FormatSize(g.minFree))
+		return g.poll(ctx, dir, resumed)
+	}
+}
+
+// poll checks dir until it has enough free space or ctx is done, then wakes the
+// writers waiting on resumed
+func (g *spaceGuard) poll(ctx context.Context, dir string, resumed chan struct{}) error {
+	defer func() {
+		g.mu.Lock()
+		delete(g.paused, dir)
+		g.mu.Unlock()
+		close(resumed)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lowSpacePoll):
+		}
+
+		free, err := utils.FreeSpace(dir)
+		if err != nil || free >= g.minFree {
+			g.mu.Lock()
+			g.checked[dir] = time.Now()
+			g.mu.Unlock()
+			g.printf("Free space in %s is back to %s, resuming\n", dir, utils.FormatSize(free))
+			return nil
+		}
+	}
+}
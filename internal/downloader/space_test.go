@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fullGuard creates a guard that finds every directory below its free space limit
+func fullGuard(pause bool) *spaceGuard {
+	return &spaceGuard{
+		minFree: math.MaxUint64,
+		pause:   pause,
+		printf:  func(string, ...any) {},
+		checked: make(map[string]time.Time),
+		paused:  make(map[string]chan struct{}),
+	}
+}
+
+func TestSpaceGuardLowSpace(t *testing.T) {
+	err := fullGuard(false).wait(context.Background(), t.TempDir())
+	if !errors.Is(err, ErrLowSpace) {
+		t.Errorf("got %v, want ErrLowSpace", err)
+	}
+}
+
+func TestSpaceGuardPauseCancel(t *testing.T) {
+	g := fullGuard(true)
+	dir := t.TempDir()
+
+	// The first writer polls the directory
+	polling, stop := context.WithCancel(context.Background())
+	defer stop()
+	first := make(chan error, 1)
+	go func() { first <- g.wait(polling, dir) }()
+	for {
+		g.mu.Lock()
+		paused := g.paused[dir] != nil
+		g.mu.Unlock()
+		if paused {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A second writer gives up as soon as its own ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan error, 1)
+	go func() { second <- g.wait(ctx, dir) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-second:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("waiting writer: got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting writer did not return after its context was cancelled")
+	}
+
+	stop()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("polling writer: got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("polling writer did not return after its context was cancelled")
+	}
+}
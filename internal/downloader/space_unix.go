@@ -0,0 +1,13 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull reports whether err comes from writing to a full disk
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorHandleDiskFull syscall.Errno = 39  // ERROR_HANDLE_DISK_FULL
+	errorDiskFull       syscall.Errno = 112 // ERROR_DISK_FULL
+)
+
+// isDiskFull reports whether err comes from writing to a full disk
+func isDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
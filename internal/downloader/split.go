@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"m3u8-downloader/internal/config"
+)
+
+// splitting reports whether the output is written as several files
+func (d *Downloader) splitting() bool {
+	return d.config.SplitDuration > 0 || d.config.SplitSize > 0 || len(d.config.SplitOn) > 0 ||
+		d.config.AdBreaks == config.AdsSplit && len(d.adBreaks) > 0
+}
+
+// partStarts marks the segments that open a new output file: after ad breaks, at
+// the requested split points and once a part holds the split duration
+func (d *Downloader) partStarts(segments []Segment) []bool {
+	starts := d.adPartStarts(segments)
+	offsets, _ := d.outputOffsets(segments)
+	every := d.config.SplitDuration
+
+	partStart := max(offsets[0], 0)
+	for i := 1; i < len(segments); i++ {
+		prev, seg := segments[i-1], segments[i]
+
+		switch {
+		case slices.Contains(d.config.SplitOn, config.SplitOnDiscontinuity) && seg.Discontinuity:
+			starts[i] = true
+		case slices.Contains(d.config.SplitOn, config.SplitOnPDT) && dateTimeJump(prev, seg):
+			starts[i] = true
+		case every > 0 && d.config.SplitAlign && !seg.ProgramDateTime.IsZero() && !prev.ProgramDateTime.IsZero():
+			// The first segment starting after a wall-clock multiple of the duration
+			starts[i] = !seg.ProgramDateTime.Truncate(every).Equal(prev.ProgramDateTime.Truncate(every))
+		case every > 0:
+			starts[i] = offsets[i]-partStart >= every-time.Millisecond
+		}
+
+		if starts[i] {
+			partStart = offsets[i]
+		}
+	}
+	return starts
+}
+
+// dateTimeJump reports whether the program date-time of seg does not follow on from prev
+func dateTimeJump(prev, seg Segment) bool {
+	if !seg.ExplicitDateTime || prev.ProgramDateTime.IsZero() {
+		return false
+	}
+	jump := seg.ProgramDateTime.Sub(prev.ProgramDateTime.Add(seconds(prev.Duration)))
+	return jump > time.Second || jump < -time.Second
+}
+
+// partNamer returns the name of each part of a split output from the name template
+func (d *Downloader) partNamer(segments []Segment) func(part, i int) string {
+	offsets, _ := d.outputOffsets(segments)
+	return func(part, i int) string {
+		return expandPartName(d.config.SplitTemplate, d.config.Output, part, segments[i], max(offsets[i], 0))
+	}
+}
+
+// expandPartName fills in a part name template. {name} is the output path without
+// its extension, {ext} the extension, {index} the part number, {seq} the media
+// sequence number of the first segment, {start} its offset in the whole output and
+// {date} its program date-time, or the current time when the playlist has none.
+func expandPartName(template, output string, part int, seg Segment, offset time.Duration) string {
+	ext := filepath.Ext(output)
+	date := seg.ProgramDateTime
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	offset = offset.Round(time.Second)
+	start := fmt.Sprintf("%02dh%02dm%02ds", int(offset.Hours()), int(offset.Minutes())%60, int(offset.Seconds())%60)
+
+	return expandTemplate(template, map[string]string{
+		"name":  strings.TrimSuffix(output, ext),
+		"ext":   ext,
+		"index": fmt.Sprintf("%02d", part),
+		"seq":   strconv.Itoa(seg.Sequence),
+		"start": start,
+		"date":  date.UTC().Format(dateFormat),
+	})
+}
@@ -0,0 +1,280 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// stallCheckInterval is how often a running transfer is checked for progress
+const stallCheckInterval = 500 * time.Millisecond
+
+// ErrStalled is returned when a segment transfer stops making progress
+var ErrStalled = errors.New("transfer stalled")
+
+// transferWatch aborts a response body read that goes idle or stays below a
+// minimum speed. Its clock stops while the rate limit holds the transfer back.
+type transferWatch struct {
+	idle     time.Duration // Longest gap between reads, unlimited when zero
+	minSpeed int64         // Bytes per second over window, unchecked when zero
+	window   time.Duration
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	start    time.Time
+	held     time.Duration // Time held back by the rate limit, including the current hold
+	holdEnd  time.Time
+	lastRead time.Duration // Clock reading of the last read
+	bytes    int64
+	samples  []speedSample
+	reason   string // Why the transfer was aborted, empty while it runs
+	done     chan struct{}
+}
+
+// speedSample is the byte count of a transfer at a clock reading
+type speedSample struct {
+	at    time.Duration
+	bytes int64
+}
+
+// watchTransfer starts watching a transfer, calling cancel when it stalls.
+// It returns nil when neither check is enabled.
+func (d *Downloader) watchTransfer(cancel context.CancelFunc) *transferWatch {
+	if d.config.IdleTimeout <= 0 && d.config.MinSpeed <= 0 {
+		return nil
+	}
+
+	w := &transferWatch{
+		idle:     d.config.IdleTimeout,
+		minSpeed: d.config.MinSpeed,
+		window:   d.config.SpeedWindow,
+		cancel:   cancel,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// clock is the time the transfer has been running, less the time it was held
+// back; it stands still during a hold. The caller holds mu.
+func (w *transferWatch) clock() time.Duration {
+	c := time.Since(w.start) - w.held
+	if left := time.Until(w.holdEnd); left > 0 {
+		c += left
+	}
+	return c
+}
+
+// run checks the transfer until it ends or stalls
+func (w *transferWatch) run() {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		w.reason = w.check(w.clock())
+		stalled := w.reason != ""
+		w.mu.Unlock()
+
+		if stalled {
+			w.cancel()
+			return
+		}
+	}
+}
+
+// check returns why the transfer counts as stalled at clock reading now, the caller holds mu
+func (w *transferWatch) check(now time.Duration) string {
+	if w.idle > 0 && now-w.lastRead >= w.idle {
+		return fmt.Sprintf("no data for %v", w.idle)
+	}
+	if w.minSpeed <= 0 {
+		return ""
+	}
+
+	w.samples = append(w.samples, speedSample{at: now, bytes: w.bytes})
+	if now < w.window {
+		return ""
+	}
+
+	// The newest sample at least a window old is the baseline
+	i := 0
+	for i+1 < len(w.samples) && w.samples[i+1].at <= now-w.window {
+		i++
+	}
+	w.samples = w.samples[i:]
+	base := w.samples[0]
+	speed := float64(w.bytes-base.bytes) / (now - base.at).Seconds()
+	if speed < float64(w.minSpeed) {
+		return fmt.Sprintf("%s/s over the last %v, below -min-speed %s/s",
+			utils.FormatSize(uint64(speed)), w.window, utils.FormatSize(uint64(w.minSpeed)))
+	}
+	return ""
+}
+
+// Reader returns r with every read counted as progress
+func (w *transferWatch) Reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return &watchedReader{r: r, watch: w}
+}
+
+// hold stops the clock for the next d, while the rate limit holds the transfer back
+func (w *transferWatch) hold(d time.Duration) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held += d
+	w.holdEnd = time.Now().Add(d)
+}
+
+// stop ends the watch
+func (w *transferWatch) stop() {
+	if w != nil {
+		close(w.done)
+	}
+}
+
+// err reports a read error caused by a stall as ErrStalled, which is retried
+func (w *transferWatch) err(err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.reason != "" {
+		return fmt.Errorf("%w: %s", ErrStalled, w.reason)
+	}
+	return err
+}
+
+// watchedReader reports the reads of a transfer to its watch
+type watchedReader struct {
+	r     io.Reader
+	watch *transferWatch
+}
+
+func (r *watchedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		w := r.watch
+		w.mu.Lock()
+		w.bytes += int64(n)
+		w.lastRead = w.clock()
+		w.mu.Unlock()
+	}
+	return n, err
+}
+
+// partialSegment is what a broken-off transfer saved, for resuming it with a range request
+type partialSegment struct {
+	url       string
+	file      string // Temporary file holding the bytes received
+	size      int64
+	validator string // ETag or Last-Modified sent as If-Range, so a changed file starts over
+}
+
+// remove deletes the saved bytes
+func (p *partialSegment) remove() {
+	os.Remove(p.file)
+}
+
+// newPartial describes the start of a segment a broken-off transfer saved in file
+func newPartial(url, file string, size int64, resp *http.Response) *partialSegment {
+	return &partialSegment{url: url, file: file, size: size, validator: rangeValidator(resp)}
+}
+
+// rangeValidator returns the ETag or Last-Modified of a response, for If-Range
+func rangeValidator(resp *http.Response) string {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// If-Range only accepts strong validators
+		validator = resp.Header.Get("Last-Modified")
+	}
+	return validator
+}
+
+// resumable reports whether a transfer that failed with err can be continued
+// with a range request
+func resumable(resp *http.Response, err error) bool {
+	return utils.IsRetryable(err) &&
+		(resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Accept-Ranges") == "bytes")
+}
+
+// keepPartial remembers the start of the segment saved as fileName for the next attempt
+func (d *Downloader) keepPartial(fileName string, p *partialSegment) {
+	d.partialMu.Lock()
+	defer d.partialMu.Unlock()
+
+	if d.partials == nil {
+		d.partials = make(map[string]*partialSegment)
+	}
+	d.partials[fileName] = p
+}
+
+// takePartial returns the saved start of the segment saved as fileName when it
+// was downloaded from url. Saved bytes from another URL are deleted.
+func (d *Downloader) takePartial(fileName, url string) *partialSegment {
+	d.partialMu.Lock()
+	defer d.partialMu.Unlock()
+
+	p, ok := d.partials[fileName]
+	if !ok {
+		return nil
+	}
+	delete(d.partials, fileName)
+	if p.url != url {
+		p.remove()
+		return nil
+	}
+	return p
+}
+
+// dropPartial deletes what a broken-off transfer of the segment saved as fileName left
+func (d *Downloader) dropPartial(fileName string) {
+	d.partialMu.Lock()
+	defer d.partialMu.Unlock()
+
+	if p, ok := d.partials[fileName]; ok {
+		p.remove()
+		delete(d.partials, fileName)
+	}
+}
+
+// resumeOffset returns where a 206 response to a range request starts, -1 when it cannot be told
+func resumeOffset(resp *http.Response) int64 {
+	// Content-Range: bytes <first>-<last>/<length>
+	value, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(value, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
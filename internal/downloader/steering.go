@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"m3u8-downloader/pkg/utils"
+)
+
+// defaultSteeringTTL is used when a steering manifest does not specify a TTL
+const defaultSteeringTTL = 300 * time.Second
+
+// steeringManifest is the JSON document served by a content steering server
+type steeringManifest struct {
+	Version         int      `json:"VERSION"`
+	TTL             int      `json:"TTL"`
+	ReloadURI       string   `json:"RELOAD-URI"`
+	PathwayPriority []string `json:"PATHWAY-PRIORITY"`
+}
+
+// loadSteering fetches a steering manifest, reporting the pathway currently in use
+func (d *Downloader) loadSteering(uri, currentPathway string) (*steeringManifest, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if currentPathway != "" {
+		q := u.Query()
+		q.Set("_HLS_pathway", currentPathway)
+		u.RawQuery = q.Encode()
+	}
+
+	content, err := d.fetchPlaylist(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest steeringManifest
+	if err := json.Unmarshal([]byte(content), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid steering manifest: %w", err)
+	}
+	if manifest.ReloadURI != "" {
+		manifest.ReloadURI = utils.ResolveURL(uri, manifest.ReloadURI)
+	}
+
+	return &manifest, nil
+}
+
+// applySteering installs the pathway priority of a steering manifest
+func (d *Downloader) applySteering(manifest *steeringManifest) {
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+	d.source.priority = manifest.PathwayPriority
+}
+
+// currentPathway returns the ID of the pathway segments are currently fetched from
+func (d *Downloader) currentPathway() string {
+	d.source.mu.Lock()
+	defer d.source.mu.Unlock()
+	return d.orderedPathways()[0].id
+}
+
+// followSteering reloads the steering manifest whenever its TTL expires until ctx is done
+func (d *Downloader) followSteering(ctx context.Context, uri string, manifest *steeringManifest) {
+	for {
+		ttl := time.Duration(manifest.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = defaultSteeringTTL
+		}
+		if manifest.ReloadURI != "" {
+			uri = manifest.ReloadURI
+		}
+
+		timer := time.NewTimer(ttl)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		next, err := d.loadSteering(uri, d.currentPathway())
+		if err != nil {
+			// Keep the last known priority and try again after another TTL
+			d.printf("\nError reloading steering manifest: %v\n", err)
+			continue
+		}
+		manifest = next
+		d.applySteering(manifest)
+	}
+}
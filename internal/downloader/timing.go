@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"m3u8-downloader/internal/mpegts"
+	"m3u8-downloader/pkg/validator"
+)
+
+// maxReportedAnomalies limits how many anomalies the timing report lists
+const maxReportedAnomalies = 20
+
+// segmentTiming is the measured timeline of one downloaded segment
+type segmentTiming struct {
+	seg      Segment
+	first    int64         // First PTS of the timing stream
+	duration time.Duration // Media duration measured from the PTS range
+	measured bool
+}
+
+// timingReport compares measured segment durations with the playlist
+type timingReport struct {
+	mu       sync.Mutex
+	segments []segmentTiming // Indexed like the downloaded segments
+}
+
+// newTimingReport prepares a report for the segments being downloaded
+func newTimingReport(segments []Segment) *timingReport {
+	r := &timingReport{segments: make([]segmentTiming, len(segments))}
+	for i, seg := range segments {
+		r.segments[i].seg = seg
+	}
+	return r
+}
+
+// record stores the timestamps of segment i
+func (r *timingReport) record(i int, info *validator.Info) {
+	stream, ok := info.Timing()
+	if !ok || stream.Count < 2 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.segments[i].first = stream.First
+	r.segments[i].duration = stream.Duration()
+	r.segments[i].measured = true
+}
+
+// anomalies lists segments whose media duration differs from EXTINF by more than
+// tolerance, and gaps or overlaps between consecutive segments
+func (r *timingReport) anomalies(tolerance time.Duration) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found []string
+	for i, t := range r.segments {
+		if !t.measured {
+			continue
+		}
+
+		extinf := seconds(t.seg.Duration)
+		if diff := t.duration - extinf; diff > tolerance || diff < -tolerance {
+			found = append(found, fmt.Sprintf("segment %d: media duration %.3fs, EXTINF %.3fs",
+				t.seg.Sequence, t.duration.Seconds(), extinf.Seconds()))
+		}
+
+		// Only neighbours in the playlist without a discontinuity share a timeline
+		if i == 0 {
+			continue
+		}
+		prev := r.segments[i-1]
+		if !prev.measured || prev.seg.Sequence+1 != t.seg.Sequence || t.seg.Discontinuity {
+			continue
+		}
+
+		expected := prev.first + int64(prev.duration)*mpegts.ClockRate/int64(time.Second)
+		delta := time.Duration(mpegts.PTSDiff(t.first, expected)) * time.Second / mpegts.ClockRate
+		switch {
+		case delta > tolerance:
+			found = append(found, fmt.Sprintf("gap of %.3fs between segments %d and %d",
+				delta.Seconds(), prev.seg.Sequence, t.seg.Sequence))
+		case delta < -tolerance:
+			found = append(found, fmt.Sprintf("overlap of %.3fs between segments %d and %d",
+				(-delta).Seconds(), prev.seg.Sequence, t.seg.Sequence))
+		}
+	}
+	return found
+}
+
+// printTimingReport prints a summary of the timing anomalies found while validating
+func (d *Downloader) printTimingReport() {
+	if d.timing == nil {
+		return
+	}
+
+	anomalies := d.timing.anomalies(d.config.TimingTolerance)
+	if len(anomalies) == 0 {
+		d.printf("Timing check: segment durations match the playlist (tolerance %v)\n", d.config.TimingTolerance)
+		return
+	}
+
+	d.printf("Timing check: %d anomalies (tolerance %v)\n", len(anomalies), d.config.TimingTolerance)
+	for i, a := range anomalies {
+		if i == maxReportedAnomalies {
+			d.printf("  ... and %d more\n", len(anomalies)-maxReportedAnomalies)
+			break
+		}
+		d.printf("  %s\n", a)
+	}
+}
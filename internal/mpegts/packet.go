@@ -0,0 +1,199 @@
+package mpegts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	PacketSize = 188  // Size of an MPEG-TS packet in bytes
+	SyncByte   = 0x47 // First byte of every packet
+	PATPID     = 0x0000
+	NullPID    = 0x1fff // Stuffing packets
+
+	// ClockRate is the frequency of PTS and DTS values
+	ClockRate = 90000
+
+	ptsWrap = 1 << 33
+)
+
+// Packet is a single 188-byte transport stream packet
+type Packet []byte
+
+// PID returns the packet identifier
+func (p Packet) PID() uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+// TransportError reports whether the transport_error_indicator is set
+func (p Packet) TransportError() bool {
+	return p[1]&0x80 != 0
+}
+
+// PayloadUnitStart reports whether a PES packet or PSI section starts in this packet
+func (p Packet) PayloadUnitStart() bool {
+	return p[1]&0x40 != 0
+}
+
+// ContinuityCounter returns the 4-bit continuity counter
+func (p Packet) ContinuityCounter() byte {
+	return p[3] & 0x0f
+}
+
+// HasAdaptationField reports whether the packet carries an adaptation field
+func (p Packet) HasAdaptationField() bool {
+	return p[3]&0x20 != 0
+}
+
+// HasPayload reports whether the packet carries payload bytes
+func (p Packet) HasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+// adaptationField returns the adaptation field without its length byte
+func (p Packet) adaptationField() []byte {
+	if !p.HasAdaptationField() {
+		return nil
+	}
+	n := int(p[4])
+	if n == 0 || 5+n > PacketSize {
+		return nil
+	}
+	return p[5 : 5+n]
+}
+
+// RandomAccess reports whether the random_access_indicator is set, marking a keyframe
+func (p Packet) RandomAccess() bool {
+	af := p.adaptationField()
+	return len(af) > 0 && af[0]&0x40 != 0
+}
+
+// Discontinuity reports whether the discontinuity_indicator is set
+func (p Packet) Discontinuity() bool {
+	af := p.adaptationField()
+	return len(af) > 0 && af[0]&0x80 != 0
+}
+
+// Payload returns the bytes after the header and adaptation field
+func (p Packet) Payload() []byte {
+	if !p.HasPayload() {
+		return nil
+	}
+	start := 4
+	if p.HasAdaptationField() {
+		start += 1 + int(p[4])
+	}
+	if start >= PacketSize {
+		return nil
+	}
+	return p[start:]
+}
+
+// PESStreamID returns the stream_id of a PES packet starting in this packet
+func (p Packet) PESStreamID() (byte, bool) {
+	if !p.PayloadUnitStart() {
+		return 0, false
+	}
+	payload := p.Payload()
+	if len(payload) < 4 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+	return payload[3], true
+}
+
+// IsVideoStreamID reports whether a PES stream_id belongs to a video stream
+func IsVideoStreamID(id byte) bool {
+	return id&0xf0 == 0xe0
+}
+
+// PTS returns the presentation timestamp of a PES packet starting in this packet
+func (p Packet) PTS() (int64, bool) {
+	if _, ok := p.PESStreamID(); !ok {
+		return 0, false
+	}
+	payload := p.Payload()
+	if len(payload) < 14 || payload[7]&0x80 == 0 {
+		return 0, false
+	}
+	return readTimestamp(payload[9:14]), true
+}
+
+// DTS returns the decoding timestamp of a PES packet starting in this packet
+func (p Packet) DTS() (int64, bool) {
+	if _, ok := p.PESStreamID(); !ok {
+		return 0, false
+	}
+	payload := p.Payload()
+	if len(payload) < 19 || payload[7]&0xc0 != 0xc0 {
+		return 0, false
+	}
+	return readTimestamp(payload[14:19]), true
+}
+
+// PCR returns the 90 kHz base of the program clock reference, if the packet carries one
+func (p Packet) PCR() (int64, bool) {
+	af := p.adaptationField()
+	if len(af) < 7 || af[0]&0x10 == 0 {
+		return 0, false
+	}
+	return int64(af[1])<<25 | int64(af[2])<<17 | int64(af[3])<<9 | int64(af[4])<<1 | int64(af[5]>>7), true
+}
+
+// readTimestamp decodes a 33-bit PTS or DTS from its 5-byte PES encoding
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// writeTimestamp encodes a 33-bit PTS or DTS, keeping the 4-bit prefix of b
+func writeTimestamp(b []byte, v int64) {
+	b[0] = b[0]&0xf0 | byte(v>>29)&0x0e | 0x01
+	b[1] = byte(v >> 22)
+	b[2] = byte(v>>14) | 0x01
+	b[3] = byte(v >> 7)
+	b[4] = byte(v<<1) | 0x01
+}
+
+// PTSDiff returns a-b in 90 kHz ticks, accounting for the 33-bit wrap-around
+func PTSDiff(a, b int64) int64 {
+	d := (a - b) & (ptsWrap - 1)
+	if d >= ptsWrap/2 {
+		d -= ptsWrap
+	}
+	return d
+}
+
+// ErrSync is returned when a packet does not start with the sync byte
+var ErrSync = errors.New("lost MPEG-TS sync")
+
+// Scan calls fn for every packet in r along with its byte offset. A trailing
+// partial packet is reported as io.ErrUnexpectedEOF. p is reused between calls.
+func Scan(r io.Reader, fn func(offset int64, p Packet) error) error {
+	br := bufio.NewReaderSize(r, 64*PacketSize)
+	buf := make([]byte, PacketSize)
+	var offset int64
+
+	for {
+		n, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated packet at offset %d (%d of %d bytes): %w", offset, n, PacketSize, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if buf[0] != SyncByte {
+			return fmt.Errorf("packet at offset %d: %w", offset, ErrSync)
+		}
+
+		if err := fn(offset, Packet(buf)); err != nil {
+			return err
+		}
+		offset += PacketSize
+	}
+}
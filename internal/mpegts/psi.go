@@ -0,0 +1,132 @@
+package mpegts
+
+import "bytes"
+
+// Stream is an elementary stream listed in a program map table
+type Stream struct {
+	PID         uint16
+	Type        byte   // stream_type from the PMT
+	Descriptors []byte // ES_info descriptors from the PMT
+}
+
+// Private PES streams (stream_type 0x06) carry audio in DVB when one of these
+// descriptors says so
+const (
+	registrationDescriptor = 0x05
+	ac3Descriptor          = 0x6a
+	eac3Descriptor         = 0x7a
+	dtsDescriptor          = 0x7b
+	aacDescriptor          = 0x7c
+)
+
+// IsVideo reports whether the stream type is a video codec
+func (s Stream) IsVideo() bool {
+	switch s.Type {
+	case 0x01, 0x02, 0x10, 0x1b, 0x24, 0x42, 0xea:
+		return true
+	}
+	return false
+}
+
+// IsAudio reports whether the stream type is an audio codec
+func (s Stream) IsAudio() bool {
+	switch s.Type {
+	case 0x03, 0x04, 0x0f, 0x11, 0x81, 0x82, 0x87:
+		return true
+	case 0x06:
+		return hasAudioDescriptor(s.Descriptors)
+	}
+	return false
+}
+
+// hasAudioDescriptor reports whether ES_info descriptors identify an audio codec
+func hasAudioDescriptor(descriptors []byte) bool {
+	for len(descriptors) >= 2 {
+		tag, length := descriptors[0], int(descriptors[1])
+		if len(descriptors) < 2+length {
+			return false
+		}
+		d := descriptors[2 : 2+length]
+		descriptors = descriptors[2+length:]
+
+		switch tag {
+		case ac3Descriptor, eac3Descriptor, dtsDescriptor, aacDescriptor:
+			return true
+		case registrationDescriptor:
+			if len(d) >= 4 {
+				switch string(d[:4]) {
+				case "AC-3", "EAC3", "DTS1", "DTS2", "DTS3", "Opus":
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// PMTPIDs parses a PAT packet and returns the PIDs of the program map tables
+func PMTPIDs(p Packet) []uint16 {
+	section := psiSection(p)
+	if len(section) < 8 || section[0] != 0x00 {
+		return nil
+	}
+
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + length - 4 // Exclude CRC
+	if end > len(section) {
+		end = len(section)
+	}
+
+	var pids []uint16
+	for i := 8; i+4 <= end; i += 4 {
+		program := uint16(section[i])<<8 | uint16(section[i+1])
+		pid := uint16(section[i+2]&0x1f)<<8 | uint16(section[i+3])
+		if program != 0 { // Program 0 points at the network PID
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// PMTStreams parses a PMT packet and returns its elementary streams
+func PMTStreams(p Packet) []Stream {
+	section := psiSection(p)
+	if len(section) < 12 || section[0] != 0x02 {
+		return nil
+	}
+
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + length - 4 // Exclude CRC
+	if end > len(section) {
+		end = len(section)
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	var streams []Stream
+	for i := 12 + programInfoLength; i+5 <= end; {
+		infoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+		streams = append(streams, Stream{
+			PID:         uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2]),
+			Type:        section[i],
+			Descriptors: bytes.Clone(section[i+5 : min(i+5+infoLength, end)]), // p is reused by Scan
+		})
+		i += 5 + infoLength
+	}
+	return streams
+}
+
+// psiSection returns the section starting in a packet, skipping the pointer field
+func psiSection(p Packet) []byte {
+	if !p.PayloadUnitStart() {
+		return nil
+	}
+	payload := p.Payload()
+	if len(payload) < 1 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer >= len(payload) {
+		return nil
+	}
+	return payload[1+pointer:]
+}
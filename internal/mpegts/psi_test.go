@@ -0,0 +1,65 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pmtPacket builds a PMT packet on PID 0x1000 listing the given streams
+func pmtPacket(streams ...Stream) Packet {
+	var entries []byte
+	for _, s := range streams {
+		entries = append(entries, s.Type, 0xe0|byte(s.PID>>8), byte(s.PID),
+			0xf0|byte(len(s.Descriptors)>>8), byte(len(s.Descriptors)))
+		entries = append(entries, s.Descriptors...)
+	}
+
+	length := 9 + len(entries) + 4
+	section := []byte{0x02, 0xb0 | byte(length>>8), byte(length), 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00}
+	section = append(section, entries...)
+	section = append(section, 0, 0, 0, 0) // CRC_32, unchecked
+
+	p := bytes.Repeat([]byte{0xff}, PacketSize)
+	copy(p, []byte{SyncByte, 0x50, 0x00, 0x10, 0x00})
+	copy(p[5:], section)
+	return p
+}
+
+func TestPMTStreamsAudio(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream Stream
+		audio  bool
+		video  bool
+	}{
+		{name: "AAC", stream: Stream{PID: 0x101, Type: 0x0f}, audio: true},
+		{name: "H.264", stream: Stream{PID: 0x100, Type: 0x1b}, video: true},
+		{name: "ATSC AC-3", stream: Stream{PID: 0x102, Type: 0x81}, audio: true},
+		{name: "DVB AC-3", stream: Stream{PID: 0x103, Type: 0x06, Descriptors: []byte{0x0a, 0x04, 'e', 'n', 'g', 0x00, 0x6a, 0x01, 0x00}}, audio: true},
+		{name: "DVB E-AC-3", stream: Stream{PID: 0x104, Type: 0x06, Descriptors: []byte{0x7a, 0x01, 0x00}}, audio: true},
+		{name: "registered E-AC-3", stream: Stream{PID: 0x105, Type: 0x06, Descriptors: []byte{0x05, 0x04, 'E', 'A', 'C', '3'}}, audio: true},
+		{name: "DVB subtitles", stream: Stream{PID: 0x106, Type: 0x06, Descriptors: []byte{0x59, 0x08, 'e', 'n', 'g', 0x10, 0x00, 0x01, 0x00, 0x01}}},
+		{name: "private PES without descriptors", stream: Stream{PID: 0x107, Type: 0x06}},
+		{name: "truncated descriptor", stream: Stream{PID: 0x108, Type: 0x06, Descriptors: []byte{0x6a, 0x05, 0x00}}},
+		{name: "ID3 metadata", stream: Stream{PID: 0x109, Type: 0x15}},
+	}
+
+	var all []Stream
+	for _, tt := range tests {
+		all = append(all, tt.stream)
+	}
+	streams := PMTStreams(pmtPacket(all...))
+	if len(streams) != len(tests) {
+		t.Fatalf("got %d streams, want %d", len(streams), len(tests))
+	}
+
+	for i, tt := range tests {
+		s := streams[i]
+		if s.PID != tt.stream.PID || s.Type != tt.stream.Type || !bytes.Equal(s.Descriptors, tt.stream.Descriptors) {
+			t.Errorf("%s: parsed as %+v, want %+v", tt.name, s, tt.stream)
+		}
+		if s.IsAudio() != tt.audio || s.IsVideo() != tt.video {
+			t.Errorf("%s: audio %v video %v, want audio %v video %v", tt.name, s.IsAudio(), s.IsVideo(), tt.audio, tt.video)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package mpegts
+
+import "io"
+
+// Restamper writes concatenated TS segments as one continuous stream. After a
+// discontinuity it shifts PCR, PTS and DTS so the new segment starts where the
+// output timeline left off, and it renumbers continuity counters across
+// segment boundaries. Segments that are not MPEG-TS are passed through.
+type Restamper struct {
+	w io.Writer
+
+	partial     []byte // Bytes of a packet split across writes
+	scratch     []byte // Copy of the packet being rewritten, Write must not modify its input
+	atStart     bool   // No bytes of the current segment seen yet
+	passthrough bool   // Current segment is not MPEG-TS
+
+	offset  int64  // Ticks added to every timestamp
+	pending bool   // Waiting for the first timestamp after a discontinuity
+	held    []byte // Packets held until the offset is known
+
+	end      int64 // End of the output timeline so far
+	hasEnd   bool
+	last     map[uint16]int64 // Last output DTS (or PTS) per PID
+	interval map[uint16]int64 // Last frame interval per PID
+	counters map[uint16]byte  // Last output continuity counter per PID
+}
+
+// NewRestamper creates a Restamper writing to w
+func NewRestamper(w io.Writer) *Restamper {
+	return &Restamper{
+		w:        w,
+		last:     make(map[uint16]int64),
+		interval: make(map[uint16]int64),
+		counters: make(map[uint16]byte),
+	}
+}
+
+// StartSegment must be called before each segment is written. discontinuity
+// marks a segment whose timestamps do not follow the previous one.
+func (r *Restamper) StartSegment(discontinuity bool) error {
+	if len(r.partial) > 0 {
+		// A truncated packet cannot be rewritten, pass it on as it is
+		if err := r.write(r.partial); err != nil {
+			return err
+		}
+		r.partial = r.partial[:0]
+	}
+
+	r.atStart = true
+	r.passthrough = false
+	if discontinuity && r.hasEnd {
+		r.pending = true
+	}
+	return nil
+}
+
+// Write rewrites the packets in b and writes them out
+func (r *Restamper) Write(b []byte) (int, error) {
+	n := len(b)
+
+	if r.atStart && len(b) > 0 {
+		r.atStart = false
+		r.passthrough = b[0] != SyncByte
+	}
+	if r.passthrough {
+		if err := r.write(b); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	if len(r.partial) > 0 {
+		need := PacketSize - len(r.partial)
+		if len(b) < need {
+			r.partial = append(r.partial, b...)
+			return n, nil
+		}
+		r.partial = append(r.partial, b[:need]...)
+		b = b[need:]
+		if err := r.packet(Packet(r.partial)); err != nil {
+			return 0, err
+		}
+		r.partial = r.partial[:0]
+	}
+
+	for len(b) >= PacketSize {
+		r.scratch = append(r.scratch[:0], b[:PacketSize]...)
+		if err := r.packet(Packet(r.scratch)); err != nil {
+			return 0, err
+		}
+		b = b[PacketSize:]
+	}
+	r.partial = append(r.partial, b...)
+
+	return n, nil
+}
+
+// Flush writes out packets still held back waiting for a timestamp
+func (r *Restamper) Flush() error {
+	if len(r.partial) > 0 {
+		if err := r.write(r.partial); err != nil {
+			return err
+		}
+		r.partial = r.partial[:0]
+	}
+
+	return r.releaseHeld()
+}
+
+// releaseHeld rewrites and writes the packets held back after a discontinuity
+func (r *Restamper) releaseHeld() error {
+	held := r.held
+	r.held = nil
+	for i := 0; i+PacketSize <= len(held); i += PacketSize {
+		if err := r.rewrite(Packet(held[i : i+PacketSize])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packet rewrites a single packet in place
+func (r *Restamper) packet(p Packet) error {
+	if p[0] != SyncByte {
+		return r.write(p)
+	}
+
+	if r.pending {
+		ts, ok := p.DTS()
+		if !ok {
+			ts, ok = p.PTS()
+		}
+		if !ok {
+			r.held = append(r.held, p...)
+			return nil
+		}
+
+		// Continue the timeline where the previous segment ended
+		r.offset = PTSDiff(r.end, ts)
+		r.pending = false
+		if err := r.releaseHeld(); err != nil {
+			return err
+		}
+	}
+
+	return r.rewrite(p)
+}
+
+// rewrite shifts the timestamps of a packet, renumbers its continuity counter and writes it
+func (r *Restamper) rewrite(p Packet) error {
+	pid := p.PID()
+
+	if pid != NullPID {
+		cc, seen := r.counters[pid]
+		switch {
+		case !seen:
+			cc = p.ContinuityCounter()
+		case p.HasPayload():
+			cc = (cc + 1) & 0x0f
+		}
+		r.counters[pid] = cc
+		p[3] = p[3]&0xf0 | cc
+
+		// The stream is continuous now, so the discontinuity flag would mislead players
+		if af := p.adaptationField(); len(af) > 0 {
+			af[0] &^= 0x80
+		}
+	}
+
+	if pcr, ok := p.PCR(); ok && r.offset != 0 {
+		af := p.adaptationField()
+		v := (pcr + r.offset) & (ptsWrap - 1)
+		af[1] = byte(v >> 25)
+		af[2] = byte(v >> 17)
+		af[3] = byte(v >> 9)
+		af[4] = byte(v >> 1)
+		af[5] = af[5]&0x7f | byte(v<<7)
+	}
+
+	if pts, ok := p.PTS(); ok {
+		payload := p.Payload()
+		pts = (pts + r.offset) & (ptsWrap - 1)
+		if r.offset != 0 {
+			writeTimestamp(payload[9:14], pts)
+		}
+
+		ts := pts
+		if dts, ok := p.DTS(); ok {
+			dts = (dts + r.offset) & (ptsWrap - 1)
+			if r.offset != 0 {
+				writeTimestamp(payload[14:19], dts)
+			}
+			ts = dts
+		}
+		r.track(pid, pts, ts)
+	}
+
+	return r.write(p)
+}
+
+// track extends the output timeline with a frame presented at pts and decoded at ts
+func (r *Restamper) track(pid uint16, pts, ts int64) {
+	if last, ok := r.last[pid]; ok {
+		if d := PTSDiff(ts, last); d > 0 {
+			r.interval[pid] = d
+		}
+	}
+	r.last[pid] = ts
+
+	end := (pts + r.interval[pid]) & (ptsWrap - 1)
+	if !r.hasEnd || PTSDiff(end, r.end) > 0 {
+		r.end = end
+		r.hasEnd = true
+	}
+}
+
+// write passes bytes to the underlying writer
+func (r *Restamper) write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(b)
+	return err
+}
@@ -0,0 +1,226 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+const testPID = 0x100
+
+// testPacket builds a packet on testPID with continuity counter cc. A PES header
+// carrying pts (and dts when not negative) is added when pts is not negative, and
+// an adaptation field carrying pcr when pcr is not negative.
+func testPacket(cc byte, pts, dts, pcr int64) []byte {
+	p := bytes.Repeat([]byte{0xff}, PacketSize)
+	p[0] = SyncByte
+	p[1] = byte(testPID >> 8)
+	p[2] = byte(testPID & 0xff)
+	p[3] = 0x10 | cc
+
+	payload := p[4:]
+	if pcr >= 0 {
+		p[3] |= 0x20
+		p[4] = 7
+		p[5] = 0x10
+		p[6] = byte(pcr >> 25)
+		p[7] = byte(pcr >> 17)
+		p[8] = byte(pcr >> 9)
+		p[9] = byte(pcr >> 1)
+		p[10] = byte(pcr<<7) | 0x7e
+		p[11] = 0
+		payload = p[12:]
+	}
+
+	if pts >= 0 {
+		p[1] |= 0x40
+		copy(payload, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5})
+		payload[9] = 0x20
+		writeTimestamp(payload[9:14], pts)
+		if dts >= 0 {
+			payload[7] = 0xc0
+			payload[8] = 10
+			payload[9] = 0x30
+			writeTimestamp(payload[9:14], pts)
+			payload[14] = 0x10
+			writeTimestamp(payload[14:19], dts)
+		}
+	}
+	return p
+}
+
+// restamp writes segments through a Restamper in writes of at most chunk bytes
+func restamp(t *testing.T, chunk int, discontinuity []bool, segments ...[]byte) []Packet {
+	t.Helper()
+
+	var out bytes.Buffer
+	r := NewRestamper(&out)
+	for i, seg := range segments {
+		if err := r.StartSegment(discontinuity[i]); err != nil {
+			t.Fatal(err)
+		}
+		for b := seg; len(b) > 0; {
+			n := min(chunk, len(b))
+			if _, err := r.Write(b[:n]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[n:]
+		}
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []Packet
+	for b := out.Bytes(); len(b) >= PacketSize; b = b[PacketSize:] {
+		packets = append(packets, Packet(b[:PacketSize]))
+	}
+	return packets
+}
+
+func TestPTSDiff(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{3000, 1000, 2000},
+		{1000, 3000, -2000},
+		{5, ptsWrap - 5, 10},
+		{ptsWrap - 5, 5, -10},
+		{0, ptsWrap - 1, 1},
+	}
+
+	for _, tt := range tests {
+		if got := PTSDiff(tt.a, tt.b); got != tt.want {
+			t.Errorf("PTSDiff(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, 90000, 1<<30 + 7, 1<<32 + 12345, ptsWrap - 1} {
+		b := []byte{0x20, 0, 0, 0, 0}
+		writeTimestamp(b, v)
+		if got := readTimestamp(b); got != v {
+			t.Errorf("timestamp %d read back as %d", v, got)
+		}
+		if b[0]&0xf0 != 0x20 || b[0]&1 != 1 || b[2]&1 != 1 || b[4]&1 != 1 {
+			t.Errorf("timestamp %d: prefix or marker bits lost in % x", v, b)
+		}
+	}
+}
+
+func TestRestamper(t *testing.T) {
+	join := func(packets ...[]byte) []byte { return bytes.Join(packets, nil) }
+
+	tests := []struct {
+		name          string
+		discontinuity []bool
+		segments      [][]byte
+		pts           []int64 // Output PTS per packet, -1 for none
+		pcr           []int64 // Output PCR per packet, -1 for none
+		cc            []byte  // Output continuity counter per packet
+	}{
+		{
+			name:          "continues after a discontinuity",
+			discontinuity: []bool{false, true},
+			segments: [][]byte{
+				join(testPacket(0, 1000, -1, -1), testPacket(1, 4000, -1, -1)),
+				join(testPacket(5, 500000, -1, -1), testPacket(6, 503000, -1, -1)),
+			},
+			pts: []int64{1000, 4000, 7000, 10000},
+			cc:  []byte{0, 1, 2, 3},
+		},
+		{
+			name:          "leaves segments without a discontinuity alone",
+			discontinuity: []bool{false, false},
+			segments: [][]byte{
+				join(testPacket(0, 1000, -1, -1), testPacket(1, 4000, -1, -1)),
+				join(testPacket(2, 500000, -1, -1)),
+			},
+			pts: []int64{1000, 4000, 500000},
+			cc:  []byte{0, 1, 2},
+		},
+		{
+			name:          "wraps around 33 bits",
+			discontinuity: []bool{false, true},
+			segments: [][]byte{
+				join(testPacket(0, ptsWrap-6000, -1, -1), testPacket(1, ptsWrap-3000, -1, -1)),
+				join(testPacket(0, 100, -1, -1), testPacket(1, 3100, -1, -1)),
+			},
+			pts: []int64{ptsWrap - 6000, ptsWrap - 3000, 0, 3000},
+			cc:  []byte{0, 1, 2, 3},
+		},
+		{
+			name:          "holds packets until the first timestamp",
+			discontinuity: []bool{false, true},
+			segments: [][]byte{
+				join(testPacket(0, 1000, -1, -1), testPacket(1, 4000, -1, -1)),
+				join(testPacket(3, -1, -1, 900000), testPacket(4, 900000, -1, -1)),
+			},
+			pts: []int64{1000, 4000, -1, 7000},
+			pcr: []int64{-1, -1, 7000, -1},
+			cc:  []byte{0, 1, 2, 3},
+		},
+		{
+			name:          "shifts by the decoding timestamp",
+			discontinuity: []bool{false, true},
+			segments: [][]byte{
+				join(testPacket(0, 1000, -1, -1), testPacket(1, 4000, -1, -1)),
+				join(testPacket(0, 206000, 200000, -1)),
+			},
+			pts: []int64{1000, 4000, 13000},
+			cc:  []byte{0, 1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, chunk := range []int{PacketSize * 4, 100} {
+			packets := restamp(t, chunk, tt.discontinuity, tt.segments...)
+			if len(packets) != len(tt.pts) {
+				t.Fatalf("%s (writes of %d): got %d packets, want %d", tt.name, chunk, len(packets), len(tt.pts))
+			}
+			for i, p := range packets {
+				pts, ok := p.PTS()
+				if !ok {
+					pts = -1
+				}
+				if pts != tt.pts[i] {
+					t.Errorf("%s (writes of %d): packet %d PTS %d, want %d", tt.name, chunk, i, pts, tt.pts[i])
+				}
+				if tt.pcr != nil {
+					pcr, ok := p.PCR()
+					if !ok {
+						pcr = -1
+					}
+					if pcr != tt.pcr[i] {
+						t.Errorf("%s (writes of %d): packet %d PCR %d, want %d", tt.name, chunk, i, pcr, tt.pcr[i])
+					}
+				}
+				if cc := p.ContinuityCounter(); cc != tt.cc[i] {
+					t.Errorf("%s (writes of %d): packet %d continuity counter %d, want %d", tt.name, chunk, i, cc, tt.cc[i])
+				}
+			}
+		}
+	}
+}
+
+func TestRestamperPassthrough(t *testing.T) {
+	other := []byte("WEBVTT\n\n00:00.000 --> 00:01.000\nhello\n")
+
+	var out bytes.Buffer
+	r := NewRestamper(&out)
+	for _, seg := range [][]byte{other, other} {
+		if err := r.StartSegment(true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Write(seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := bytes.Repeat(other, 2); !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("got %q, want the segments unchanged", out.Bytes())
+	}
+}
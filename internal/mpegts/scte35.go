@@ -0,0 +1,111 @@
+package mpegts
+
+import "errors"
+
+// SpliceSignal is what a SCTE-35 message says about the ad break state
+type SpliceSignal int
+
+const (
+	SpliceNone SpliceSignal = iota // Not a break boundary
+	SpliceOut                      // Leaving the network feed: an ad break starts
+	SpliceIn                       // Returning to the network feed: the break ends
+)
+
+const (
+	spliceInsert           = 0x05
+	spliceTimeSignal       = 0x06
+	segmentationDescriptor = 0x02
+)
+
+// ErrSCTE35 is returned for a splice_info_section that cannot be decoded
+var ErrSCTE35 = errors.New("malformed SCTE-35 splice_info_section")
+
+// ParseSpliceInfo decodes a SCTE-35 splice_info_section and reports whether it
+// starts or ends an ad break. splice_insert commands are read from their
+// out_of_network_indicator, time_signal commands from the segmentation type of
+// their segmentation descriptors.
+func ParseSpliceInfo(b []byte) (SpliceSignal, error) {
+	if len(b) < 14 || b[0] != 0xfc {
+		return SpliceNone, ErrSCTE35
+	}
+	if b[4]&0x80 != 0 { // Encrypted packet
+		return SpliceNone, nil
+	}
+
+	commandLength := int(b[11]&0x0f)<<8 | int(b[12])
+	commandType := b[13]
+	command := b[14:]
+
+	switch commandType {
+	case spliceInsert:
+		if len(command) < 6 {
+			return SpliceNone, ErrSCTE35
+		}
+		if command[4]&0x80 != 0 { // splice_event_cancel_indicator
+			return SpliceNone, nil
+		}
+		if command[5]&0x80 != 0 {
+			return SpliceOut, nil
+		}
+		return SpliceIn, nil
+
+	case spliceTimeSignal:
+		if commandLength == 0x0fff || len(command) < commandLength+2 {
+			return SpliceNone, ErrSCTE35
+		}
+		loop := command[commandLength:]
+		loopLength := int(loop[0])<<8 | int(loop[1])
+		descriptors := loop[2:]
+		if loopLength < len(descriptors) {
+			descriptors = descriptors[:loopLength]
+		}
+		return segmentationSignal(descriptors)
+	}
+
+	return SpliceNone, nil
+}
+
+// segmentationSignal looks for break start and end types in segmentation descriptors
+func segmentationSignal(descriptors []byte) (SpliceSignal, error) {
+	for len(descriptors) >= 2 {
+		tag, length := descriptors[0], int(descriptors[1])
+		if len(descriptors) < 2+length {
+			return SpliceNone, ErrSCTE35
+		}
+		d := descriptors[2 : 2+length]
+		descriptors = descriptors[2+length:]
+
+		// Identifier "CUEI", event ID and the cancel flag come first
+		if tag != segmentationDescriptor || len(d) < 10 || string(d[:4]) != "CUEI" || d[8]&0x80 != 0 {
+			continue
+		}
+
+		flags := d[9]
+		pos := 10
+		if flags&0x80 == 0 { // Component mode lists the components
+			if pos >= len(d) {
+				return SpliceNone, ErrSCTE35
+			}
+			pos += 1 + 6*int(d[pos])
+		}
+		if flags&0x40 != 0 { // segmentation_duration
+			pos += 5
+		}
+		pos++ // segmentation_upid_type
+		if pos >= len(d) {
+			return SpliceNone, ErrSCTE35
+		}
+		pos += 1 + int(d[pos]) // segmentation_upid_length and upid
+		if pos >= len(d) {
+			return SpliceNone, ErrSCTE35
+		}
+
+		switch d[pos] {
+		case 0x22, 0x30, 0x32, 0x34, 0x36, 0x38, 0x3a, 0x3c, 0x44, 0x46:
+			return SpliceOut, nil
+		case 0x23, 0x31, 0x33, 0x35, 0x37, 0x39, 0x3b, 0x3d, 0x45, 0x47:
+			return SpliceIn, nil
+		}
+	}
+	return SpliceNone, nil
+}
@@ -0,0 +1,139 @@
+package mpegts
+
+import (
+	"errors"
+	"testing"
+)
+
+// spliceSection builds a splice_info_section around a command and its descriptors
+func spliceSection(commandType byte, command, descriptors []byte) []byte {
+	b := []byte{
+		0xfc, 0x30, 0x00, // table_id, section_length (unchecked)
+		0x00,                         // protocol_version
+		0x00, 0x00, 0x00, 0x00, 0x00, // encrypted_packet, encryption_algorithm, pts_adjustment
+		0x00,                                                   // cw_index
+		0xff, 0xf0 | byte(len(command)>>8), byte(len(command)), // tier, splice_command_length
+		commandType,
+	}
+	b = append(b, command...)
+	b = append(b, byte(len(descriptors)>>8), byte(len(descriptors)))
+	b = append(b, descriptors...)
+	return append(b, 0, 0, 0, 0) // CRC_32, unchecked
+}
+
+// insertCommand builds a splice_insert command for an immediate program splice
+func insertCommand(out, cancel bool) []byte {
+	c := []byte{0x00, 0x00, 0x00, 0x01, 0x7f, 0x5f}
+	if cancel {
+		c[4] |= 0x80
+	}
+	if out {
+		c[5] |= 0x80
+	}
+	return c
+}
+
+// timeSignal is a time_signal command with a specified splice time
+var timeSignal = []byte{0xfe, 0x00, 0x01, 0x5f, 0x90}
+
+// segmentation builds a segmentation_descriptor. flags carries
+// program_segmentation_flag and segmentation_duration_flag, fields the components
+// and duration they announce.
+func segmentation(flags byte, fields, upid []byte, typeID byte, cancel bool) []byte {
+	d := []byte("CUEI")
+	d = append(d, 0x00, 0x00, 0x00, 0x2a) // segmentation_event_id
+	if cancel {
+		return append([]byte{segmentationDescriptor, byte(len(d) + 1)}, append(d, 0xff)...)
+	}
+	d = append(d, 0x7f, flags|0x3f)
+	d = append(d, fields...)
+	d = append(d, 0x0c, byte(len(upid)))
+	d = append(d, upid...)
+	d = append(d, typeID, 0x01, 0x01) // segmentation_type_id, segment_num, segments_expected
+	return append([]byte{segmentationDescriptor, byte(len(d))}, d...)
+}
+
+func TestParseSpliceInfo(t *testing.T) {
+	const program = 0x80
+	duration := []byte{0x00, 0x00, 0x29, 0x32, 0xe0}
+	components := []byte{2, 0x01, 0xfe, 0, 0, 0, 0, 0x02, 0xfe, 0, 0, 0, 0}
+	upid := []byte("ABCD0001")
+	other := []byte{0x00, 0x08, 'C', 'U', 'E', 'I', 0x00, 0x00, 0x01, 0x00} // avail_descriptor
+
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		want SpliceSignal
+		err  bool
+	}{
+		{name: "splice_insert out", in: spliceSection(spliceInsert, insertCommand(true, false), nil), want: SpliceOut},
+		{name: "splice_insert in", in: spliceSection(spliceInsert, insertCommand(false, false), nil), want: SpliceIn},
+		{name: "splice_insert cancelled", in: spliceSection(spliceInsert, insertCommand(true, true), nil), want: SpliceNone},
+		{name: "splice_null", in: spliceSection(0x00, nil, nil), want: SpliceNone},
+
+		{name: "placement opportunity start",
+			in:   spliceSection(spliceTimeSignal, timeSignal, segmentation(program, nil, nil, 0x34, false)),
+			want: SpliceOut},
+		{name: "placement opportunity end",
+			in:   spliceSection(spliceTimeSignal, timeSignal, segmentation(program, nil, nil, 0x35, false)),
+			want: SpliceIn},
+		{name: "program start is no break",
+			in:   spliceSection(spliceTimeSignal, timeSignal, segmentation(program, nil, nil, 0x10, false)),
+			want: SpliceNone},
+		{name: "duration and UPID",
+			in:   spliceSection(spliceTimeSignal, timeSignal, segmentation(program|0x40, duration, upid, 0x30, false)),
+			want: SpliceOut},
+		{name: "component mode",
+			in:   spliceSection(spliceTimeSignal, timeSignal, segmentation(0x40, join(components, duration), upid, 0x37, false)),
+			want: SpliceIn},
+		{name: "time not specified",
+			in:   spliceSection(spliceTimeSignal, []byte{0x7f}, segmentation(program, nil, nil, 0x22, false)),
+			want: SpliceOut},
+		{name: "other descriptors skipped",
+			in: spliceSection(spliceTimeSignal, timeSignal, join(other,
+				segmentation(program, nil, nil, 0x36, true),
+				segmentation(program, nil, nil, 0x36, false))),
+			want: SpliceOut},
+
+		{name: "encrypted", in: func() []byte {
+			b := spliceSection(spliceInsert, insertCommand(true, false), nil)
+			b[4] |= 0x80
+			return b
+		}(), want: SpliceNone},
+
+		{name: "too short", in: []byte{0xfc, 0x30, 0x00}, err: true},
+		{name: "wrong table", in: func() []byte {
+			b := spliceSection(spliceInsert, insertCommand(true, false), nil)
+			b[0] = 0x00
+			return b
+		}(), err: true},
+		{name: "truncated splice_insert", in: spliceSection(spliceInsert, insertCommand(true, false)[:4], nil)[:18], err: true},
+		{name: "truncated descriptor", in: func() []byte {
+			d := segmentation(program, nil, upid, 0x34, false)
+			return spliceSection(spliceTimeSignal, timeSignal, d[:len(d)-5])
+		}(), err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSpliceInfo(tt.in)
+		if tt.err {
+			if !errors.Is(err, ErrSCTE35) {
+				t.Errorf("%s: got %v, %v, want ErrSCTE35", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package mpegts
+
+import (
+	"io"
+	"time"
+)
+
+// NoBound leaves one side of a Trim range open
+const NoBound time.Duration = -1
+
+// keyframe is a video random access point within a segment
+type keyframe struct {
+	offset int64
+	pts    int64
+}
+
+// segmentIndex is what Trim learns about a segment in its first pass
+type segmentIndex struct {
+	firstPTS  int64
+	hasPTS    bool
+	keyframes []keyframe
+}
+
+// indexSegment records the first timestamp and the video keyframes of a segment
+func indexSegment(r io.Reader) (*segmentIndex, error) {
+	idx := &segmentIndex{}
+	var firstVideo, firstAny int64
+	var hasVideo, hasAny bool
+
+	err := Scan(r, func(offset int64, p Packet) error {
+		id, ok := p.PESStreamID()
+		if !ok {
+			return nil
+		}
+		pts, ok := p.PTS()
+		if !ok {
+			return nil
+		}
+
+		if !hasAny {
+			firstAny, hasAny = pts, true
+		}
+		if IsVideoStreamID(id) {
+			if !hasVideo {
+				firstVideo, hasVideo = pts, true
+			}
+			if p.RandomAccess() {
+				idx.keyframes = append(idx.keyframes, keyframe{offset: offset, pts: pts})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case hasVideo:
+		idx.firstPTS, idx.hasPTS = firstVideo, true
+	case hasAny:
+		idx.firstPTS, idx.hasPTS = firstAny, true
+	}
+	return idx, nil
+}
+
+// Trim copies a TS segment to w, keeping only the part between start and end,
+// both measured from the segment's first timestamp. Video is cut at keyframes:
+// the output begins at the last keyframe at or before start and stops before
+// the first keyframe at or after end. Other streams are cut at the PES packets
+// matching those keyframes, and PAT/PMT packets are always kept.
+// open is called twice, once to index the segment and once to copy it.
+func Trim(open func() (io.ReadCloser, error), w io.Writer, start, end time.Duration) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	idx, err := indexSegment(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	// Without timestamps there is nothing to cut on
+	if !idx.hasPTS {
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	startOffset, endOffset := int64(0), int64(-1)
+	var startPTS, endPTS int64
+	hasStart, hasEnd := start != NoBound, end != NoBound
+
+	if hasStart {
+		target := idx.firstPTS + durationToTicks(start)
+		startPTS = target
+		for i, kf := range idx.keyframes {
+			if i > 0 && PTSDiff(kf.pts, target) > 0 {
+				break
+			}
+			startOffset, startPTS = kf.offset, kf.pts
+		}
+	}
+
+	if hasEnd {
+		endPTS = idx.firstPTS + durationToTicks(end)
+		for _, kf := range idx.keyframes {
+			if kf.offset > startOffset && PTSDiff(kf.pts, endPTS) >= 0 {
+				endOffset, endPTS = kf.offset, kf.pts
+				break
+			}
+		}
+	}
+
+	r, err = open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	psi := map[uint16]bool{PATPID: true}
+	keep := make(map[uint16]bool) // Whether the PES in progress on a PID is kept
+
+	return Scan(r, func(offset int64, p Packet) error {
+		pid := p.PID()
+
+		if pid == PATPID {
+			for _, pmt := range PMTPIDs(p) {
+				psi[pmt] = true
+			}
+		}
+		if psi[pid] {
+			_, err := w.Write(p)
+			return err
+		}
+
+		if p.PayloadUnitStart() {
+			id, isPES := p.PESStreamID()
+			pts, hasPTS := p.PTS()
+			switch {
+			case !isPES:
+				keep[pid] = offset >= startOffset && (endOffset < 0 || offset < endOffset)
+			case IsVideoStreamID(id) && len(idx.keyframes) > 0:
+				// Video follows the keyframe cut points by position, keeping reordered frames
+				keep[pid] = offset >= startOffset && (endOffset < 0 || offset < endOffset)
+			case hasPTS:
+				keep[pid] = offset >= startOffset &&
+					(!hasStart || PTSDiff(pts, startPTS) >= 0) &&
+					(!hasEnd || PTSDiff(pts, endPTS) < 0)
+			default:
+				keep[pid] = offset >= startOffset && (endOffset < 0 || offset < endOffset)
+			}
+		}
+
+		if !keep[pid] {
+			return nil
+		}
+		_, err := w.Write(p)
+		return err
+	})
+}
+
+// durationToTicks converts a duration to 90 kHz clock ticks
+func durationToTicks(d time.Duration) int64 {
+	return int64(d) * ClockRate / int64(time.Second)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadCookieFile reads a Netscape cookies.txt file into jar
+func LoadCookieFile(jar http.CookieJar, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: expected 7 tab separated fields, got %d", fileName, lineNum, len(fields))
+		}
+
+		domain := fields[0]
+		includeSubdomains := strings.EqualFold(fields[1], "TRUE")
+		secure := strings.EqualFold(fields[3], "TRUE")
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid expiry %q", fileName, lineNum, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if includeSubdomains {
+			cookie.Domain = domain
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: "/"}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package utils
+
+import "errors"
+
+// FreeSpace is not available on this platform
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// SameVolume reports whether two paths are on the same volume, assumed when unknown
+func SameVolume(a, b string) bool {
+	return true
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to unprivileged users on the volume holding dir
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// SameVolume reports whether two paths are on the same volume
+func SameVolume(a, b string) bool {
+	ia, errA := os.Stat(a)
+	ib, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	sa, okA := ia.Sys().(*syscall.Stat_t)
+	sb, okB := ib.Sys().(*syscall.Stat_t)
+	return okA && okB && sa.Dev == sb.Dev
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Header is an extra request header, optionally limited to a set of hosts
+type Header struct {
+	Name  string
+	Value string
+	Hosts []string // Empty applies the header to every host
+}
+
+// ParseHeader parses "Name: Value", optionally prefixed with "@host1,host2 " to scope it
+func ParseHeader(s string) (Header, error) {
+	var h Header
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "@") {
+		scope, rest, found := strings.Cut(s[1:], " ")
+		if !found {
+			return h, fmt.Errorf("invalid header %q: missing header after host scope", s)
+		}
+		h.Hosts = SplitList(scope)
+		s = strings.TrimSpace(rest)
+	}
+
+	name, value, found := strings.Cut(s, ":")
+	if !found || strings.TrimSpace(name) == "" {
+		return h, fmt.Errorf("invalid header %q: expected \"Name: Value\"", s)
+	}
+
+	h.Name = strings.TrimSpace(name)
+	h.Value = strings.TrimSpace(value)
+	return h, nil
+}
+
+// BasicAuthHeader builds an Authorization header from "user:password" credentials
+func BasicAuthHeader(credentials string, hosts []string) Header {
+	return Header{
+		Name:  "Authorization",
+		Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+		Hosts: hosts,
+	}
+}
+
+// BearerAuthHeader builds an Authorization header carrying a bearer token
+func BearerAuthHeader(token string, hosts []string) Header {
+	return Header{
+		Name:  "Authorization",
+		Value: "Bearer " + token,
+		Hosts: hosts,
+	}
+}
+
+// Matches reports whether the header should be sent to host
+func (h Header) Matches(host string) bool {
+	if len(h.Hosts) == 0 {
+		return true
+	}
+	return MatchHost(host, h.Hosts)
+}
+
+// MatchHost reports whether host equals or is a subdomain of any pattern
+func MatchHost(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(p), "."))
+		if p == "" {
+			continue
+		}
+		if p == "*" || host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList splits a comma separated list, dropping empty entries
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"time"
@@ -13,6 +15,132 @@ import (
 // UserAgent is the default user agent string used for HTTP requests
 const UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
 
+// ClientOptions configures the requests made by a Client
+type ClientOptions struct {
+	UserAgent  string   // Overrides UserAgent when set
+	Headers    []Header // Extra headers added to matching requests
+	CookieFile string   // Netscape cookies.txt loaded into the cookie jar
+	MaxConns   int      // Idle connections kept per host
+	// Connection setup (TCP and TLS handshake each) and the wait for response
+	// headers after the request is sent; zero leaves them unbounded
+	ConnectTimeout   time.Duration
+	FirstByteTimeout time.Duration
+	Proxy            ProxyOptions
+	TLS              TLSOptions
+}
+
+// Client performs HTTP requests sharing one transport, header set and cookie jar
+type Client struct {
+	httpClient *http.Client
+	userAgent  string
+	headers    []Header
+}
+
+var defaultClient = &Client{httpClient: &http.Client{}, userAgent: UserAgent}
+
+// NewClient creates a Client from the provided options
+func NewClient(opts ClientOptions) (*Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CookieFile != "" {
+		if err := LoadCookieFile(jar, opts.CookieFile); err != nil {
+			return nil, fmt.Errorf("error loading cookies: %w", err)
+		}
+	}
+
+	maxConns := opts.MaxConns
+	if maxConns <= 0 {
+		maxConns = 5
+	}
+
+	proxy, err := opts.Proxy.proxyFunc()
+	if err != nil {
+		return nil, fmt.Errorf("error configuring proxy: %w", err)
+	}
+
+	tlsConfig, err := opts.TLS.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error configuring TLS: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.FirstByteTimeout,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		DisableCompression:    true,
+		MaxIdleConnsPerHost:   maxConns,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	c := &Client{
+		userAgent: opts.UserAgent,
+		headers:   opts.Headers,
+	}
+	if c.userAgent == "" {
+		c.userAgent = UserAgent
+	}
+
+	c.httpClient = &http.Client{
+		Transport:     &hostTransport{base: transport, opts: opts.TLS, perHost: make(map[string]*http.Transport)},
+		Jar:           jar,
+		CheckRedirect: c.checkRedirect,
+	}
+
+	return c, nil
+}
+
+// NewRequest builds a GET request carrying the user agent and every header scoped to its host
+func (c *Client) NewRequest(ctx context.Context, urlStr string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "*/*")
+	for _, h := range c.headers {
+		if h.Matches(req.URL.Hostname()) {
+			req.Header.Set(h.Name, h.Value)
+		}
+	}
+
+	return req, nil
+}
+
+// Do sends a request using the shared transport and cookie jar
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.httpClient.Do(req)
+}
+
+// checkRedirect keeps host-scoped headers from following a redirect to another
+// host. Unscoped headers are left as net/http copied them, which drops
+// Authorization and Cookie on a redirect to another domain.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+
+	host := req.URL.Hostname()
+	for _, h := range c.headers {
+		switch {
+		case len(h.Hosts) == 0:
+		case h.Matches(host):
+			req.Header.Set(h.Name, h.Value)
+		default:
+			req.Header.Del(h.Name)
+		}
+	}
+
+	return nil
+}
+
 // GetBaseURL extracts the base URL from a full URL string
 func GetBaseURL(urlStr string) (string, error) {
 	parsedURL, err := url.Parse(urlStr)
@@ -29,25 +157,27 @@ func GetBaseURL(urlStr string) (string, error) {
 
 // FetchURL retrieves content from a URL with timeout
 func FetchURL(urlStr string, timeout time.Duration) (string, error) {
+	return defaultClient.Fetch(urlStr, timeout)
+}
+
+// Fetch retrieves content from a URL with timeout
+func (c *Client) Fetch(urlStr string, timeout time.Duration) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	req, err := c.NewRequest(ctx, urlStr)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("User-Agent", UserAgent)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP status code: %d", resp.StatusCode)
+		return "", NewHTTPError(resp)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyOptions configures how requests are routed through proxies
+type ProxyOptions struct {
+	URL     string      // http://, https://, socks5:// or socks5h:// proxy URL, "direct" disables proxies
+	NoProxy []string    // Hosts always contacted directly
+	Rules   []ProxyRule // Per-host overrides, first match wins
+}
+
+// ProxyRule routes requests for matching hosts through a specific proxy
+type ProxyRule struct {
+	Hosts []string
+	Proxy *url.URL // nil connects directly
+}
+
+// ParseProxyRule parses "host1,host2=PROXY_URL" or "host1,host2=direct"
+func ParseProxyRule(s string) (ProxyRule, error) {
+	var rule ProxyRule
+
+	hosts, target, found := strings.Cut(s, "=")
+	if !found {
+		return rule, fmt.Errorf("invalid proxy rule %q: expected hosts=proxy", s)
+	}
+
+	rule.Hosts = SplitList(hosts)
+	if len(rule.Hosts) == 0 {
+		return rule, fmt.Errorf("invalid proxy rule %q: no hosts", s)
+	}
+
+	proxyURL, err := ParseProxyURL(strings.TrimSpace(target))
+	if err != nil {
+		return rule, fmt.Errorf("invalid proxy rule %q: %w", s, err)
+	}
+	rule.Proxy = proxyURL
+
+	return rule, nil
+}
+
+// ParseProxyURL validates a proxy URL, returning nil for "direct"
+func ParseProxyURL(s string) (*url.URL, error) {
+	if s == "" || strings.EqualFold(s, "direct") {
+		return nil, nil
+	}
+
+	// Accept bare host:port as an HTTP proxy like curl does
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", s)
+	}
+
+	return u, nil
+}
+
+// proxyFunc returns the Transport.Proxy function for the options
+func (o ProxyOptions) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	explicit, err := ParseProxyURL(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	direct := strings.EqualFold(o.URL, "direct")
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+
+		for _, rule := range o.Rules {
+			if MatchHost(host, rule.Hosts) {
+				return rule.Proxy, nil
+			}
+		}
+
+		if MatchHost(host, o.NoProxy) || direct {
+			return nil, nil
+		}
+
+		if explicit != nil {
+			return explicit, nil
+		}
+
+		// Fall back to HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+		return http.ProxyFromEnvironment(req)
+	}, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of numbers, open-ended when Last is -1
+type Range struct {
+	First int
+	Last  int
+}
+
+// Contains reports whether n is in the range
+func (r Range) Contains(n int) bool {
+	return n >= r.First && (r.Last < 0 || n <= r.Last)
+}
+
+// ParseRanges parses a comma separated list such as "10-20,50,100-"
+func ParseRanges(s string) ([]Range, error) {
+	var ranges []Range
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+		r := Range{Last: -1}
+		var err error
+		if r.First, err = strconv.Atoi(strings.TrimSpace(first)); err != nil || r.First < 0 {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+
+		switch {
+		case !isRange:
+			r.Last = r.First
+		case strings.TrimSpace(last) != "":
+			if r.Last, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || r.Last < r.First {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("empty range list %q", s)
+	}
+	return ranges, nil
+}
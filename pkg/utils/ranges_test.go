@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Range
+		err  bool
+	}{
+		{in: "5", want: []Range{{5, 5}}},
+		{in: "10-20", want: []Range{{10, 20}}},
+		{in: "100-", want: []Range{{100, -1}}},
+		{in: "0-0", want: []Range{{0, 0}}},
+		{in: "10-20,50,100-", want: []Range{{10, 20}, {50, 50}, {100, -1}}},
+		{in: " 1 - 3 , 7 ", want: []Range{{1, 3}, {7, 7}}},
+		{in: "1,,2,", want: []Range{{1, 1}, {2, 2}}},
+
+		{in: "", err: true},
+		{in: ",", err: true},
+		{in: "-5", err: true},
+		{in: "20-10", err: true},
+		{in: "1-2-3", err: true},
+		{in: "a-b", err: true},
+		{in: "5-x", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRanges(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseRanges(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRanges(%q): %v", tt.in, err)
+		} else if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseRanges(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	tests := []struct {
+		r    Range
+		n    int
+		want bool
+	}{
+		{Range{10, 20}, 9, false},
+		{Range{10, 20}, 10, true},
+		{Range{10, 20}, 20, true},
+		{Range{10, 20}, 21, false},
+		{Range{100, -1}, 99, false},
+		{Range{100, -1}, 100, true},
+		{Range{100, -1}, 1 << 30, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.Contains(tt.n); got != tt.want {
+			t.Errorf("%v.Contains(%d) = %v, want %v", tt.r, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bounds of the reads a rate-limited body is split into, so throttled
+// transfers advance in small steps instead of long pauses
+const (
+	minRateChunk = 1 << 10
+	maxRateChunk = 32 << 10
+)
+
+// RateLimiter is a token bucket limiting the bytes per second read by every
+// request that shares it. The rate can be changed while transfers are running.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, unlimited when zero
+	tokens float64 // Bytes that may be read now, negative while in debt
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for rate bytes per second, unlimited when zero
+func NewRateLimiter(rate int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(rate)
+	return l
+}
+
+// Rate returns the current limit in bytes per second, zero when unlimited
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// SetRate changes the limit to rate bytes per second, unlimited when zero
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate = float64(max(rate, 0))
+
+	// Debt run up at the old rate would otherwise stall readers at the new one
+	l.tokens = max(l.tokens, -float64(l.chunk()))
+	if l.rate == 0 {
+		l.tokens = 0
+	}
+}
+
+// refill adds the tokens earned since the last update, at most one second's worth
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 && !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	}
+	l.last = now
+}
+
+// chunk is the largest read taken at the current rate, about an eighth of a second
+func (l *RateLimiter) chunk() int {
+	if l.rate == 0 {
+		return maxRateChunk
+	}
+	return min(max(int(l.rate/8), minRateChunk), maxRateChunk)
+}
+
+// WaitN accounts for n bytes read and blocks until the rate allows them
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	return sleep(ctx, l.reserve(n))
+}
+
+// reserve accounts for n bytes read and returns how long to wait before the next read
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader returns r throttled by the limiter, giving up when ctx is done. When
+// hold is not nil it is told how long a read will be held back before the wait.
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader, hold func(time.Duration)) io.Reader {
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: l, hold: hold}
+}
+
+// rateLimitedReader reads through a RateLimiter
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+	hold    func(time.Duration)
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	r.limiter.mu.Lock()
+	limited, chunk := r.limiter.rate > 0, r.limiter.chunk()
+	r.limiter.mu.Unlock()
+	if limited && len(p) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 && limited {
+		wait := r.limiter.reserve(n)
+		if wait > 0 && r.hold != nil {
+			r.hold(wait)
+		}
+		if werr := sleep(r.ctx, wait); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
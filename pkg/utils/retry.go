@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps how long a server-provided Retry-After can stall a request
+const maxRetryAfter = 10 * time.Minute
+
+// HTTPError reports a response with an unexpected status code
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration // Parsed Retry-After header, zero when absent
+}
+
+// NewHTTPError builds an HTTPError from a response
+func NewHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{StatusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP status code: %d", e.StatusCode)
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// permanentError marks an error that must not be retried
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that IsRetryable reports false for it
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// RetryPolicy retries transient failures with exponential backoff and jitter
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration // Delay before the first retry
+	MaxDelay   time.Duration // Upper bound for the backoff delay
+}
+
+// Backoff returns the jittered delay before the given retry attempt (1-based)
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = time.Second
+	}
+
+	delay := base
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	// Equal jitter: half fixed, half random, so retries from many workers spread out
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Delay returns how long to wait before retrying after err, honoring Retry-After
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		if httpErr.RetryAfter > maxRetryAfter {
+			return maxRetryAfter
+		}
+		return httpErr.RetryAfter
+	}
+	return p.Backoff(attempt)
+}
+
+// Do runs op until it succeeds, fails permanently, or runs out of retries.
+// onRetry, when not nil, is called before each wait.
+func (p RetryPolicy) Do(ctx context.Context, op func() error, onRetry func(attempt int, err error, wait time.Duration)) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		if attempt > p.MaxRetries || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.Delay(attempt, err)
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// IsRetryable classifies an error as transient (worth retrying) or permanent
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var permanent *permanentError
+	if errors.Is(err, context.Canceled) || errors.As(err, &permanent) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+			return true
+		}
+		// Other 4xx responses will not change on retry, 5xx usually do
+		return httpErr.StatusCode >= 500
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	// Certificate problems are configuration errors
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &invalidCert) {
+		return false
+	}
+
+	// Timeouts, resets, refused connections and truncated bodies are transient
+	return true
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimePoint is a position in a stream, either an offset from its start or a
+// wall-clock time matched against EXT-X-PROGRAM-DATE-TIME
+type TimePoint struct {
+	Offset time.Duration
+	Wall   time.Time // Set for wall-clock times, Offset is unused then
+}
+
+// IsWall reports whether the point is a wall-clock time
+func (t TimePoint) IsWall() bool {
+	return !t.Wall.IsZero()
+}
+
+// String formats the point the way it was given
+func (t TimePoint) String() string {
+	if t.IsWall() {
+		return t.Wall.Format(time.RFC3339)
+	}
+	return FormatOffset(t.Offset)
+}
+
+// ParseTimePoint parses an RFC 3339 wall-clock time, an offset as HH:MM:SS[.mmm],
+// MM:SS or seconds, or a Go duration such as 1h2m3s
+func ParseTimePoint(s string) (TimePoint, error) {
+	s = strings.TrimSpace(s)
+
+	if wall, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return TimePoint{Wall: wall}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil && strings.ContainsAny(s, "hms") {
+		if d < 0 {
+			return TimePoint{}, fmt.Errorf("invalid time %q: must not be negative", s)
+		}
+		return TimePoint{Offset: d}, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return TimePoint{}, fmt.Errorf("invalid time %q, expected HH:MM:SS, seconds or an RFC 3339 time", s)
+	}
+
+	var total float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || !(v >= 0) || math.IsInf(v, 1) || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return TimePoint{}, fmt.Errorf("invalid time %q, expected HH:MM:SS, seconds or an RFC 3339 time", s)
+		}
+		total = total*60 + v
+	}
+	if total >= math.MaxInt64/float64(time.Second) {
+		return TimePoint{}, fmt.Errorf("invalid time %q: too large", s)
+	}
+
+	return TimePoint{Offset: time.Duration(total * float64(time.Second))}, nil
+}
+
+// FormatOffset formats an offset as HH:MM:SS.mmm
+func FormatOffset(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimePoint(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset time.Duration
+		wall   string
+		err    bool
+	}{
+		{in: "90", offset: 90 * time.Second},
+		{in: "1.5", offset: 1500 * time.Millisecond},
+		{in: "02:30", offset: 150 * time.Second},
+		{in: "02:30.250", offset: 150*time.Second + 250*time.Millisecond},
+		{in: "1:02:30", offset: time.Hour + 2*time.Minute + 30*time.Second},
+		{in: " 0:00:05 ", offset: 5 * time.Second},
+		{in: "1h2m3s", offset: time.Hour + 2*time.Minute + 3*time.Second},
+		{in: "45m", offset: 45 * time.Minute},
+		{in: "2562047:00:00", offset: 2562047 * time.Hour},
+		{in: "2024-05-01T18:30:00Z", wall: "2024-05-01T18:30:00Z"},
+		{in: "2024-05-01T20:30:00.5+02:00", wall: "2024-05-01T18:30:00.5Z"},
+
+		{in: "", err: true},
+		{in: "abc", err: true},
+		{in: "-5", err: true},
+		{in: "-1h", err: true},
+		{in: "1:-2", err: true},
+		{in: "1:2:3:4", err: true},
+		{in: "1.5:30", err: true},
+		{in: "NaN", err: true},
+		{in: "Inf", err: true},
+		{in: "1e12", err: true},
+		{in: "2562048:00:00", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimePoint(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseTimePoint(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTimePoint(%q): %v", tt.in, err)
+			continue
+		}
+
+		if tt.wall != "" {
+			want, _ := time.Parse(time.RFC3339Nano, tt.wall)
+			if !got.IsWall() || !got.Wall.Equal(want) {
+				t.Errorf("ParseTimePoint(%q) = %v, want wall-clock %v", tt.in, got, want)
+			}
+			continue
+		}
+		if got.IsWall() || got.Offset != tt.offset {
+			t.Errorf("ParseTimePoint(%q) = %v, want offset %v", tt.in, got, tt.offset)
+		}
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "00:00:00.000"},
+		{1500 * time.Millisecond, "00:00:01.500"},
+		{time.Hour + 2*time.Minute + 30*time.Second, "01:02:30.000"},
+		{100 * time.Hour, "100:00:00.000"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatOffset(tt.in); got != tt.want {
+			t.Errorf("FormatOffset(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
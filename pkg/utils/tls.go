@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TLSOptions configures certificate verification and client authentication
+type TLSOptions struct {
+	CAFile      string            // PEM bundle trusted in addition to the system roots
+	ClientCerts []ClientCert      // Offered when a server asks for a client certificate
+	MinVersion  uint16            // Minimum TLS version, tls.VersionTLS12 when zero
+	ServerNames map[string]string // SNI and verification name overrides keyed by host
+	Pins        []Pin             // Public-key pins checked against the peer chain
+	Insecure    bool              // Skip certificate verification (pins still apply)
+}
+
+// ClientCert is a client certificate and its private key, both PEM encoded
+type ClientCert struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Pin restricts the public keys accepted for a set of hosts
+type Pin struct {
+	Hosts  []string
+	Hashes []string // base64 SHA-256 digests of the SubjectPublicKeyInfo
+}
+
+// ParseClientCert parses "CERT[,KEY]", using CERT for both when the key is omitted
+func ParseClientCert(s string) ClientCert {
+	certFile, keyFile, found := strings.Cut(s, ",")
+	if !found {
+		keyFile = certFile
+	}
+	return ClientCert{CertFile: strings.TrimSpace(certFile), KeyFile: strings.TrimSpace(keyFile)}
+}
+
+// ParsePin parses "host1,host2=sha256//BASE64[;sha256//BASE64...]"
+func ParsePin(s string) (Pin, error) {
+	var pin Pin
+
+	hosts, hashes, found := strings.Cut(s, "=")
+	if !found {
+		return pin, fmt.Errorf("invalid pin %q: expected hosts=sha256//HASH", s)
+	}
+
+	pin.Hosts = SplitList(hosts)
+	if len(pin.Hosts) == 0 {
+		return pin, fmt.Errorf("invalid pin %q: no hosts", s)
+	}
+
+	for _, h := range strings.Split(hashes, ";") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		h = strings.TrimPrefix(h, "sha256//")
+		if raw, err := base64.StdEncoding.DecodeString(h); err != nil || len(raw) != sha256.Size {
+			return pin, fmt.Errorf("invalid pin %q: %q is not a base64 SHA-256 digest", s, h)
+		}
+		pin.Hashes = append(pin.Hashes, h)
+	}
+
+	if len(pin.Hashes) == 0 {
+		return pin, fmt.Errorf("invalid pin %q: no hashes", s)
+	}
+
+	return pin, nil
+}
+
+// ParseTLSVersion converts "1.0" through "1.3" to a crypto/tls version constant
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), "tls") {
+	case "":
+		return 0, nil
+	case "1.0", "1":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", s)
+}
+
+// tlsConfig builds the base TLS configuration shared by every host
+func (o TLSOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         o.MinVersion,
+		InsecureSkipVerify: o.Insecure,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	// With several pairs, crypto/tls offers the first one accepted by the server's CA list
+	for _, cc := range o.ClientCerts {
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate %s: %w", cc.CertFile, err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	return cfg, nil
+}
+
+// hasOverride reports whether host needs its own TLS configuration
+func (o TLSOptions) hasOverride(host string) bool {
+	if _, ok := o.ServerNames[host]; ok {
+		return true
+	}
+	return o.pinsFor(host) != nil
+}
+
+// pinsFor returns the accepted SPKI hashes for host, or nil when it is not pinned
+func (o TLSOptions) pinsFor(host string) map[string]bool {
+	var hashes map[string]bool
+	for _, pin := range o.Pins {
+		if !MatchHost(host, pin.Hosts) {
+			continue
+		}
+		if hashes == nil {
+			hashes = make(map[string]bool)
+		}
+		for _, h := range pin.Hashes {
+			hashes[h] = true
+		}
+	}
+	return hashes
+}
+
+// hostTLSConfig derives the configuration for a host with an SNI override or pins
+func (o TLSOptions) hostTLSConfig(base *tls.Config, host string) *tls.Config {
+	cfg := base.Clone()
+
+	serverName := host
+	if sni, ok := o.ServerNames[host]; ok {
+		serverName = sni
+		cfg.ServerName = sni
+	}
+
+	// crypto/tls never sends IP addresses as SNI
+	if net.ParseIP(serverName) != nil {
+		serverName = ""
+	}
+
+	if pins := o.pinsFor(host); pins != nil {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			// The same config also dials HTTPS proxies, which are not pinned
+			if cs.ServerName != serverName {
+				return nil
+			}
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return Permanent(fmt.Errorf("public key pin mismatch for %s", host))
+		}
+	}
+
+	return cfg
+}
+
+// hostTransport routes requests through per-host clones of a transport when TLS overrides apply
+type hostTransport struct {
+	base    *http.Transport
+	opts    TLSOptions
+	mu      sync.Mutex
+	perHost map[string]*http.Transport
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if req.URL.Scheme != "https" || !t.opts.hasOverride(host) {
+		return t.base.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	transport, ok := t.perHost[host]
+	if !ok {
+		transport = t.base.Clone()
+		transport.TLSClientConfig = t.opts.hostTLSConfig(t.base.TLSClientConfig, host)
+		t.perHost[host] = transport
+	}
+	t.mu.Unlock()
+
+	return transport.RoundTrip(req)
+}
@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// adtsValidator validates packed AAC audio in ADTS frames
+type adtsValidator struct{}
+
+func (adtsValidator) Name() string { return "AAC/ADTS" }
+
+// Detect looks for an ADTS sync word, after the ID3 tag packed audio starts with
+func (adtsValidator) Detect(header []byte) bool {
+	b := skipID3Header(header)
+	return len(b) >= 2 && b[0] == 0xff && b[1]&0xf6 == 0xf0
+}
+
+// Validate walks every ADTS frame, checking sync words and truncation at the tail
+func (adtsValidator) Validate(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	if err := skipID3(br); err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	frames := 0
+	header := make([]byte, 7)
+
+	for {
+		_, err := io.ReadFull(br, header)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated frame header at offset %d", offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header[0] != 0xff || header[1]&0xf6 != 0xf0 {
+			return nil, fmt.Errorf("lost ADTS sync at offset %d", offset)
+		}
+		length := int64(header[3]&0x03)<<11 | int64(header[4])<<3 | int64(header[5]>>5)
+		if length < int64(len(header)) {
+			return nil, fmt.Errorf("invalid frame length %d at offset %d", length, offset)
+		}
+
+		if _, err := io.CopyN(io.Discard, br, length-int64(len(header))); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("truncated frame at offset %d", offset)
+			}
+			return nil, err
+		}
+		offset += length
+		frames++
+	}
+
+	if frames == 0 {
+		return nil, fmt.Errorf("no ADTS frames found")
+	}
+	return &Info{}, nil
+}
+
+// id3Size returns the length of the ID3v2 tag at the start of b, or 0
+func id3Size(b []byte) int {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return 0
+	}
+	size := int(b[6]&0x7f)<<21 | int(b[7]&0x7f)<<14 | int(b[8]&0x7f)<<7 | int(b[9]&0x7f)
+	size += 10
+	if b[5]&0x10 != 0 { // Footer present
+		size += 10
+	}
+	return size
+}
+
+// skipID3Header returns header without a leading ID3v2 tag
+func skipID3Header(header []byte) []byte {
+	n := id3Size(header)
+	if n > len(header) {
+		return nil
+	}
+	return header[n:]
+}
+
+// skipID3 discards a leading ID3v2 tag, such as the timestamp tag of HLS packed audio
+func skipID3(br *bufio.Reader) error {
+	header, err := br.Peek(10)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	n := id3Size(header)
+	if n == 0 {
+		return nil
+	}
+	if _, err := br.Discard(n); err != nil {
+		return fmt.Errorf("truncated ID3 tag")
+	}
+	return nil
+}
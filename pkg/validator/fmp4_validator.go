@@ -0,0 +1,102 @@
+package validator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// fmp4Validator validates fragmented MP4 / CMAF segments
+type fmp4Validator struct{}
+
+func (fmp4Validator) Name() string { return "fMP4" }
+
+// Detect looks for a box type that can open an init or media segment
+func (fmp4Validator) Detect(header []byte) bool {
+	if len(header) < 8 {
+		return false
+	}
+	switch string(header[4:8]) {
+	case "ftyp", "styp", "sidx", "moof", "moov", "emsg", "prft":
+		return true
+	}
+	return false
+}
+
+// Validate walks the top-level boxes, checking that each one is complete, and
+// requires either a movie box (init segment) or a fragment with its media data
+func (fmp4Validator) Validate(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	seen := make(map[string]bool)
+	var offset int64
+	header := make([]byte, 16)
+
+	for {
+		_, err := io.ReadFull(br, header[:8])
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated box header at offset %d", offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		if !isBoxType(header[4:8]) {
+			return nil, fmt.Errorf("invalid box type %q at offset %d", boxType, offset)
+		}
+		seen[boxType] = true
+
+		headerLen := int64(8)
+		switch size {
+		case 0: // Box extends to the end of the segment
+			n, err := io.Copy(io.Discard, br)
+			if err != nil {
+				return nil, err
+			}
+			offset += headerLen + n
+			return checkFragment(seen)
+		case 1: // 64-bit size follows the type
+			if _, err := io.ReadFull(br, header[8:16]); err != nil {
+				return nil, fmt.Errorf("truncated %s box header at offset %d", boxType, offset)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen {
+			return nil, fmt.Errorf("invalid %s box size %d at offset %d", boxType, size, offset)
+		}
+
+		if _, err := io.CopyN(io.Discard, br, size-headerLen); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("truncated %s box at offset %d", boxType, offset)
+			}
+			return nil, err
+		}
+		offset += size
+	}
+
+	return checkFragment(seen)
+}
+
+// checkFragment confirms the boxes seen make up an init or media segment
+func checkFragment(seen map[string]bool) (*Info, error) {
+	if seen["moov"] || (seen["moof"] && seen["mdat"]) {
+		return &Info{}, nil
+	}
+	return nil, fmt.Errorf("no moov box and no moof/mdat pair found")
+}
+
+// isBoxType reports whether a box type consists of printable characters
+func isBoxType(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
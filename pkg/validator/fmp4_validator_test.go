@@ -0,0 +1,57 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// box builds an MP4 box with a 32-bit size around payload
+func box(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, boxType...), payload...)
+}
+
+// largeBox builds an MP4 box with a 64-bit size around payload
+func largeBox(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, boxType...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(payload)))
+	return append(b, payload...)
+}
+
+func TestFMP4Validate(t *testing.T) {
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	ftyp := box("ftyp", []byte("iso6\x00\x00\x00\x00"))
+	moov := box("moov", box("mvhd", make([]byte, 100)))
+	styp := box("styp", []byte("msdh\x00\x00\x00\x00"))
+	moof := box("moof", box("mfhd", make([]byte, 8)))
+	mdat := box("mdat", make([]byte, 300))
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  bool
+	}{
+		{name: "init segment", in: join(ftyp, moov)},
+		{name: "media segment", in: join(styp, moof, mdat)},
+		{name: "64-bit mdat", in: join(styp, moof, largeBox("mdat", make([]byte, 300)))},
+		{name: "mdat to the end", in: join(moof, []byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}, make([]byte, 50))},
+
+		{name: "truncated box", in: join(styp, moof, mdat[:len(mdat)-1]), err: true},
+		{name: "truncated box header", in: join(ftyp, moov, mdat[:5]), err: true},
+		{name: "truncated 64-bit size", in: join(styp, moof, largeBox("mdat", nil)[:12]), err: true},
+		{name: "size below header", in: join(ftyp, []byte{0, 0, 0, 4, 'm', 'o', 'o', 'v'}), err: true},
+		{name: "garbage box type", in: join(ftyp, moov, []byte{0, 0, 0, 8, 0x00, 0x01, 0x02, 0x03}), err: true},
+		{name: "fragment without media data", in: join(styp, moof), err: true},
+	}
+
+	for _, tt := range tests {
+		_, err := fmp4Validator{}.Validate(bytes.NewReader(tt.in))
+		if tt.err && err == nil {
+			t.Errorf("%s: passed, want an error", tt.name)
+		} else if !tt.err && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package validator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// mp3Validator validates raw MPEG audio (MP1/MP2/MP3) segments
+type mp3Validator struct{}
+
+func (mp3Validator) Name() string { return "MP3" }
+
+// Detect looks for an MPEG audio frame header, after an optional ID3 tag
+func (mp3Validator) Detect(header []byte) bool {
+	b := skipID3Header(header)
+	if len(b) < 4 {
+		return false
+	}
+	_, err := mpegAudioFrameLength(b[:4])
+	return err == nil
+}
+
+// Validate walks every frame, checking frame headers and truncation at the tail
+func (mp3Validator) Validate(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	if err := skipID3(br); err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	frames := 0
+	header := make([]byte, 4)
+
+	for {
+		_, err := io.ReadFull(br, header)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated frame header at offset %d", offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// An ID3v1 tag may close the stream
+		if string(header[:3]) == "TAG" {
+			if _, err := br.Discard(128 - len(header)); err != nil {
+				return nil, fmt.Errorf("truncated ID3v1 tag at offset %d", offset)
+			}
+			offset += 128
+			continue
+		}
+
+		length, err := mpegAudioFrameLength(header)
+		if err != nil {
+			return nil, fmt.Errorf("%v at offset %d", err, offset)
+		}
+
+		if _, err := io.CopyN(io.Discard, br, length-int64(len(header))); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("truncated frame at offset %d", offset)
+			}
+			return nil, err
+		}
+		offset += length
+		frames++
+	}
+
+	if frames == 0 {
+		return nil, fmt.Errorf("no MPEG audio frames found")
+	}
+	return &Info{}, nil
+}
+
+// Bitrates in kbit/s indexed by [table][bitrate_index]
+var mpegAudioBitrates = [5][15]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}, // MPEG-1 Layer I
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},    // MPEG-1 Layer II
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},     // MPEG-1 Layer III
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},    // MPEG-2/2.5 Layer I
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},         // MPEG-2/2.5 Layer II and III
+}
+
+// mpegAudioFrameLength decodes a 4-byte MPEG audio frame header into the frame length
+func mpegAudioFrameLength(h []byte) (int64, error) {
+	if h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return 0, fmt.Errorf("lost frame sync")
+	}
+
+	version := h[1] >> 3 & 0x03 // 3: MPEG-1, 2: MPEG-2, 0: MPEG-2.5
+	layer := h[1] >> 1 & 0x03   // 3: Layer I, 2: Layer II, 1: Layer III
+	bitrateIndex := h[2] >> 4
+	rateIndex := h[2] >> 2 & 0x03
+	padding := int64(h[2] >> 1 & 0x01)
+
+	if version == 1 || layer == 0 || bitrateIndex == 0x0f || rateIndex == 3 {
+		return 0, fmt.Errorf("invalid frame header")
+	}
+	if bitrateIndex == 0 {
+		return 0, fmt.Errorf("free-format bitrate is not supported")
+	}
+
+	sampleRate := int64([3]int{44100, 48000, 32000}[rateIndex])
+	var table int
+	switch {
+	case version == 3:
+		table = int(3 - layer)
+	case layer == 3:
+		table = 3
+	default:
+		table = 4
+	}
+	if version == 2 {
+		sampleRate /= 2
+	} else if version == 0 {
+		sampleRate /= 4
+	}
+	bitrate := int64(mpegAudioBitrates[table][bitrateIndex]) * 1000
+
+	switch {
+	case layer == 3:
+		return (12*bitrate/sampleRate + padding) * 4, nil
+	case layer == 1 && version != 3:
+		return 72*bitrate/sampleRate + padding, nil
+	default:
+		return 144*bitrate/sampleRate + padding, nil
+	}
+}
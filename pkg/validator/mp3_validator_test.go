@@ -0,0 +1,45 @@
+package validator
+
+import "testing"
+
+func TestMPEGAudioFrameLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   int64
+		err    bool
+	}{
+		{name: "MPEG-1 Layer III 128k 44.1kHz", header: []byte{0xff, 0xfb, 0x90, 0x00}, want: 417},
+		{name: "MPEG-1 Layer III 128k 44.1kHz padded", header: []byte{0xff, 0xfb, 0x92, 0x00}, want: 418},
+		{name: "MPEG-1 Layer III 320k 48kHz", header: []byte{0xff, 0xfb, 0xe4, 0x00}, want: 960},
+		{name: "MPEG-1 Layer III 32k 32kHz", header: []byte{0xff, 0xfb, 0x18, 0x00}, want: 144},
+		{name: "MPEG-2 Layer III 64k 22.05kHz", header: []byte{0xff, 0xf3, 0x80, 0x00}, want: 208},
+		{name: "MPEG-2 Layer III 64k 22.05kHz padded", header: []byte{0xff, 0xf3, 0x82, 0x00}, want: 209},
+		{name: "MPEG-2 Layer III 160k 24kHz", header: []byte{0xff, 0xf3, 0xe4, 0x00}, want: 480},
+		{name: "MPEG-2.5 Layer III 8k 11.025kHz", header: []byte{0xff, 0xe3, 0x10, 0x00}, want: 52},
+		{name: "MPEG-1 Layer II 192k 48kHz", header: []byte{0xff, 0xfd, 0xa4, 0x00}, want: 576},
+		{name: "MPEG-1 Layer I 32k 44.1kHz", header: []byte{0xff, 0xff, 0x10, 0x00}, want: 32},
+
+		{name: "lost sync", header: []byte{0xfe, 0xfb, 0x90, 0x00}, err: true},
+		{name: "reserved version", header: []byte{0xff, 0xeb, 0x90, 0x00}, err: true},
+		{name: "reserved layer", header: []byte{0xff, 0xf9, 0x90, 0x00}, err: true},
+		{name: "bad bitrate", header: []byte{0xff, 0xfb, 0xf0, 0x00}, err: true},
+		{name: "reserved sample rate", header: []byte{0xff, 0xfb, 0x9c, 0x00}, err: true},
+		{name: "free format", header: []byte{0xff, 0xfb, 0x00, 0x00}, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := mpegAudioFrameLength(tt.header)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got length %d, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got length %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
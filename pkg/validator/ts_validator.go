@@ -0,0 +1,141 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"m3u8-downloader/internal/mpegts"
+)
+
+// tsValidator validates MPEG-TS segments
+type tsValidator struct{}
+
+func (tsValidator) Name() string { return "MPEG-TS" }
+
+// Detect looks for sync bytes at the start of the first two packets
+func (tsValidator) Detect(header []byte) bool {
+	return len(header) > 0 && header[0] == mpegts.SyncByte &&
+		(len(header) <= mpegts.PacketSize || header[mpegts.PacketSize] == mpegts.SyncByte)
+}
+
+func (tsValidator) Validate(r io.Reader) (*Info, error) {
+	return InspectTS(r)
+}
+
+// ValidateTS checks if a TS file appears to be valid
+func ValidateTS(fileName string) error {
+	// Open the file
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ValidateTSReader(file)
+}
+
+// ValidateTSReader walks every packet of a TS stream, checking sync bytes,
+// per-PID continuity counters and truncation at the tail, and confirms that
+// the PAT and PMT describe an audio or video stream that carries data
+func ValidateTSReader(r io.Reader) error {
+	_, err := InspectTS(r)
+	return err
+}
+
+// InspectTS validates a TS stream like ValidateTSReader and collects the
+// timestamps of its audio and video streams
+func InspectTS(r io.Reader) (*Info, error) {
+	pmtPIDs := make(map[uint16]bool)
+	streams := make(map[uint16]mpegts.Stream)
+	counters := make(map[uint16]byte)
+	carried := make(map[uint16]bool) // Elementary PIDs seen with payload
+	timings := make(map[uint16]*StreamTiming)
+	packets := 0
+
+	err := mpegts.Scan(r, func(offset int64, p mpegts.Packet) error {
+		packets++
+		pid := p.PID()
+
+		if p.TransportError() {
+			return fmt.Errorf("packet at offset %d has the transport error flag set", offset)
+		}
+
+		// The counter only advances on packets with payload; a single repeat is allowed
+		if pid != mpegts.NullPID && p.HasPayload() {
+			cc := p.ContinuityCounter()
+			if last, ok := counters[pid]; ok && !p.Discontinuity() && cc != last && cc != (last+1)&0x0f {
+				return fmt.Errorf("continuity error on PID 0x%04x at offset %d: expected %d, got %d",
+					pid, offset, (last+1)&0x0f, cc)
+			}
+			counters[pid] = cc
+		}
+
+		switch {
+		case pid == mpegts.PATPID:
+			for _, pmt := range mpegts.PMTPIDs(p) {
+				pmtPIDs[pmt] = true
+			}
+		case pmtPIDs[pid]:
+			for _, s := range mpegts.PMTStreams(p) {
+				streams[s.PID] = s
+			}
+		case p.HasPayload():
+			carried[pid] = true
+			if pts, ok := p.PTS(); ok {
+				recordPTS(timings, pid, pts)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if packets == 0 {
+		return nil, fmt.Errorf("file too small to be a valid TS segment")
+	}
+	if len(pmtPIDs) == 0 {
+		return nil, fmt.Errorf("no PAT found")
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("no PMT found")
+	}
+
+	info := &Info{}
+	hasMedia := false
+	for pid, s := range streams {
+		if !(s.IsVideo() || s.IsAudio()) || !carried[pid] {
+			continue
+		}
+		hasMedia = true
+		if t, ok := timings[pid]; ok {
+			t.Video = s.IsVideo()
+			info.Streams = append(info.Streams, *t)
+		}
+	}
+	if !hasMedia {
+		return nil, fmt.Errorf("no audio or video data found")
+	}
+
+	sort.Slice(info.Streams, func(i, j int) bool { return info.Streams[i].PID < info.Streams[j].PID })
+	return info, nil
+}
+
+// recordPTS widens the timestamp range of a PID, accounting for wrap-around
+func recordPTS(timings map[uint16]*StreamTiming, pid uint16, pts int64) {
+	t, ok := timings[pid]
+	if !ok {
+		timings[pid] = &StreamTiming{PID: pid, First: pts, Last: pts, Count: 1}
+		return
+	}
+
+	t.Count++
+	if mpegts.PTSDiff(pts, t.First) < 0 {
+		t.First = pts
+	}
+	if mpegts.PTSDiff(pts, t.Last) > 0 {
+		t.Last = pts
+	}
+}
@@ -0,0 +1,121 @@
+package validator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"m3u8-downloader/internal/mpegts"
+)
+
+// Validator checks downloaded segments of one container format
+type Validator interface {
+	// Name identifies the container in error messages
+	Name() string
+	// Detect reports whether a segment starting with header is in this container
+	Detect(header []byte) bool
+	// Validate reads the whole segment and reports the first problem found
+	Validate(r io.Reader) (*Info, error)
+}
+
+// detectSize is how many leading bytes of a segment are passed to Detect
+const detectSize = 512
+
+// ErrUnknownFormat is returned when no registered validator recognizes a segment
+var ErrUnknownFormat = errors.New("unrecognized segment format")
+
+var (
+	registryMu sync.RWMutex
+	registry   = []Validator{
+		tsValidator{},
+		fmp4Validator{},
+		webVTTValidator{},
+		adtsValidator{},
+		mp3Validator{},
+	}
+)
+
+// Register adds a validator. Validators registered later are tried first, so
+// they can take over containers that a built-in validator also detects.
+func Register(v Validator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append([]Validator{v}, registry...)
+}
+
+// Detect returns the validator for a segment starting with header, or nil
+func Detect(header []byte) Validator {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, v := range registry {
+		if v.Detect(header) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Validate detects the container of a segment and validates it
+func Validate(r io.Reader) (*Info, error) {
+	br := bufio.NewReaderSize(r, detectSize)
+	header, err := br.Peek(detectSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 0 {
+		return nil, fmt.Errorf("segment is empty")
+	}
+
+	v := Detect(header)
+	if v == nil {
+		return nil, ErrUnknownFormat
+	}
+
+	info, err := v.Validate(br)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", v.Name(), err)
+	}
+	return info, nil
+}
+
+// StreamTiming holds the presentation timestamps seen on one elementary stream
+type StreamTiming struct {
+	PID   uint16
+	Video bool
+	First int64 // Lowest PTS
+	Last  int64 // Highest PTS
+	Count int   // PES packets carrying a PTS
+}
+
+// Duration estimates the media duration, counting one average frame interval after the last PTS
+func (s StreamTiming) Duration() time.Duration {
+	if s.Count < 2 {
+		return 0
+	}
+	ticks := mpegts.PTSDiff(s.Last, s.First) * int64(s.Count) / int64(s.Count-1)
+	return time.Duration(ticks) * time.Second / mpegts.ClockRate
+}
+
+// Info describes a segment that passed validation. Streams is empty for
+// containers whose validator does not extract timestamps.
+type Info struct {
+	Streams []StreamTiming // Audio and video streams with timestamps, in PID order
+}
+
+// Timing returns the stream that best represents the segment timeline:
+// the first video stream, otherwise the first audio stream
+func (info *Info) Timing() (StreamTiming, bool) {
+	for _, s := range info.Streams {
+		if s.Video {
+			return s, true
+		}
+	}
+	if len(info.Streams) > 0 {
+		return info.Streams[0], true
+	}
+	return StreamTiming{}, false
+}
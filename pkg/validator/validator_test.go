@@ -0,0 +1,98 @@
+package validator
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// adtsFrame builds an AAC frame of length bytes with a 7-byte ADTS header
+func adtsFrame(length int) []byte {
+	f := make([]byte, length)
+	copy(f, []byte{0xff, 0xf1, 0x50, 0x80 | byte(length>>11)&0x03, byte(length >> 3), byte(length<<5) | 0x1f, 0xfc})
+	return f
+}
+
+// mp3Frame builds an MPEG-1 Layer III frame at 128 kbit/s and 44.1 kHz
+func mp3Frame() []byte {
+	f := make([]byte, 417)
+	copy(f, []byte{0xff, 0xfb, 0x90, 0x00})
+	return f
+}
+
+// id3Tag builds an empty ID3v2 tag with size bytes of padding
+func id3Tag(size int) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, make([]byte, size)...)
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string // Validator name, empty when none
+	}{
+		{name: "ADTS", header: adtsFrame(64), want: "AAC/ADTS"},
+		{name: "ADTS after ID3", header: append(id3Tag(20), adtsFrame(64)...), want: "AAC/ADTS"},
+		{name: "MP3", header: mp3Frame(), want: "MP3"},
+		{name: "MP3 after ID3", header: append(id3Tag(20), mp3Frame()...), want: "MP3"},
+		{name: "MPEG-2 Layer III", header: []byte{0xff, 0xf3, 0x80, 0x00}, want: "MP3"},
+		{name: "MPEG-TS", header: bytes.Repeat(append([]byte{0x47}, make([]byte, 187)...), 2), want: "MPEG-TS"},
+		{name: "fMP4", header: []byte{0, 0, 0, 16, 'f', 't', 'y', 'p', 'i', 's', 'o', '6', 0, 0, 0, 0}, want: "fMP4"},
+		{name: "WebVTT", header: []byte("\ufeffWEBVTT\n\n"), want: "WebVTT"},
+		{name: "text", header: []byte("hello world")},
+		{name: "MPEG-2 ADTS", header: []byte{0xff, 0xf9, 0x50, 0x80, 0x08, 0x1f, 0xfc}, want: "AAC/ADTS"},
+		{name: "sync word with reserved version", header: []byte{0xff, 0xeb, 0x90, 0x00}},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if v := Detect(tt.header); v != nil {
+			got = v.Name()
+		}
+		if got != tt.want {
+			t.Errorf("%s: detected %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateUnknownFormat(t *testing.T) {
+	for _, in := range []string{"hello world", "<html><body>403 Forbidden</body></html>"} {
+		if _, err := Validate(bytes.NewReader([]byte(in))); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("Validate(%q): got %v, want ErrUnknownFormat", in, err)
+		}
+	}
+
+	if _, err := Validate(bytes.NewReader(nil)); err == nil || errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Validate of an empty segment: got %v, want an error other than ErrUnknownFormat", err)
+	}
+}
+
+func TestValidateAudio(t *testing.T) {
+	adts := bytes.Join([][]byte{adtsFrame(64), adtsFrame(100), adtsFrame(80)}, nil)
+	mp3 := bytes.Repeat(mp3Frame(), 3)
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  bool
+	}{
+		{name: "ADTS", in: adts},
+		{name: "ADTS after ID3", in: append(id3Tag(30), adts...)},
+		{name: "ADTS truncated frame", in: adts[:len(adts)-1], err: true},
+		{name: "ADTS truncated header", in: append(adts, adtsFrame(64)[:5]...), err: true},
+		{name: "ADTS lost sync", in: append(adts, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06), err: true},
+		{name: "MP3", in: mp3},
+		{name: "MP3 with ID3v1 tag", in: append(mp3, append([]byte("TAG"), make([]byte, 125)...)...)},
+		{name: "MP3 truncated frame", in: mp3[:len(mp3)-10], err: true},
+	}
+
+	for _, tt := range tests {
+		_, err := Validate(bytes.NewReader(tt.in))
+		if tt.err && err == nil {
+			t.Errorf("%s: passed, want an error", tt.name)
+		} else if !tt.err && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package validator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark a WebVTT file may start with
+const utf8BOM = "\ufeff"
+
+// webVTTValidator validates WebVTT subtitle segments
+type webVTTValidator struct{}
+
+func (webVTTValidator) Name() string { return "WebVTT" }
+
+// Detect looks for the WEBVTT signature, after an optional byte order mark
+func (webVTTValidator) Detect(header []byte) bool {
+	return strings.HasPrefix(strings.TrimPrefix(string(header), utf8BOM), "WEBVTT")
+}
+
+// Validate checks the signature line, UTF-8 encoding and every cue timing line
+func (webVTTValidator) Validate(r io.Reader) (*Info, error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		text := scanner.Text()
+		line++
+
+		if !utf8.ValidString(text) {
+			return nil, fmt.Errorf("line %d is not valid UTF-8", line)
+		}
+
+		if line == 1 {
+			text = strings.TrimPrefix(text, utf8BOM)
+			if text != "WEBVTT" && !strings.HasPrefix(text, "WEBVTT ") && !strings.HasPrefix(text, "WEBVTT\t") {
+				return nil, fmt.Errorf("missing WEBVTT signature")
+			}
+			continue
+		}
+
+		if !strings.Contains(text, "-->") {
+			continue
+		}
+
+		start, rest, _ := strings.Cut(text, "-->")
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: cue has no end time", line)
+		}
+		startMs, err := parseCueTime(strings.TrimSpace(start))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		endMs, err := parseCueTime(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if endMs < startMs {
+			return nil, fmt.Errorf("line %d: cue ends before it starts", line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, fmt.Errorf("missing WEBVTT signature")
+	}
+	return &Info{}, nil
+}
+
+// parseCueTime parses a cue timestamp as [hh:]mm:ss.ttt into milliseconds
+func parseCueTime(s string) (int64, error) {
+	var h, m, sec, ms int64
+	parts := strings.Split(s, ":")
+	var err error
+	switch len(parts) {
+	case 2:
+		_, err = fmt.Sscanf(s, "%d:%d.%d", &m, &sec, &ms)
+	case 3:
+		_, err = fmt.Sscanf(s, "%d:%d:%d.%d", &h, &m, &sec, &ms)
+	default:
+		err = fmt.Errorf("wrong number of fields")
+	}
+	if err != nil || m > 59 || sec > 59 || ms > 999 || !strings.Contains(s, ".") {
+		return 0, fmt.Errorf("invalid cue timestamp %q", s)
+	}
+	return ((h*60+m)*60+sec)*1000 + ms, nil
+}